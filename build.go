@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/parallelblock/yate/cmd"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Render every resource declared in the config file",
+	Long: `Build renders every resource declared in the config file, writing each
+one to its output path. Every resource is attempted even if an earlier one
+fails, and the command exits with an error if any of them did.
+
+Variables can be set over those in the config file with --var key=value, or
+with YATE_VAR_<key> environment variables, where __ separates the keys of a
+nested variable. Flags win over environment variables.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		b, err := projectBuilder()
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, r := range b.BuildAll() {
+			if r.Err != nil {
+				failed++
+			}
+			reportBuild(r)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d resource(s) failed to build", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	addVarFlag(buildCmd)
+	cmd.AddCommand(buildCmd)
+}
+
+var projectVars []string
+
+// addVarFlag adds the --var flag, which sets variables over those in the
+// config file, to a command that builds resources.
+func addVarFlag(c *cobra.Command) {
+	c.Flags().StringArrayVar(&projectVars, "var", nil, "set a variable over those in the config file, as key=value (repeatable)")
+}
+
+// projectOverrides returns the variables set by YATE_VAR_ environment
+// variables and --var flags, with flags winning where both set one.
+func projectOverrides() (*Overrides, error) {
+	o := NewOverrides()
+	o.SetEnv(os.Environ())
+	if err := o.SetFlags(projectVars); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// project locates everything relative to the directory of the config file.
+type project struct {
+	File string
+	Dir  string
+}
+
+func loadProject() (*project, error) {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return nil, errors.New("no config file found - pass one with --config or create resources.toml")
+	}
+	// viper finds resources.yaml, resources.json and so on too, which would
+	// only be misread as TOML
+	if !strings.EqualFold(filepath.Ext(file), ".toml") {
+		return nil, fmt.Errorf("%s: config must be TOML, in a .toml file", file)
+	}
+	return &project{file, filepath.Dir(file)}, nil
+}
+
+func (p *project) Path(path string) string {
+	return filepath.Join(p.Dir, path)
+}
+
+func (p *project) Source() (*TomlConfigSource, error) {
+	return LoadTomlConfigSource(p.File)
+}
+
+func (p *project) ReadFile(filename string) ([]byte, error) {
+	return ioutil.ReadFile(p.Path(filename))
+}
+
+// Glob matches pattern within the project, returning paths relative to it.
+func (p *project) Glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(p.Path(pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		if matches[i], err = filepath.Rel(p.Dir, m); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+func (p *project) Stat(filename string) (os.FileInfo, error) {
+	return os.Stat(p.Path(filename))
+}
+
+func (p *project) Open(path string) (io.WriteCloser, error) {
+	path = p.Path(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func reportBuild(r BuildResult) {
+	if r.Err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", r.Resource, r.Err)
+		return
+	}
+	fmt.Printf("ok   %s\n", r.Resource)
+}
+
+// projectBuilder creates a builder for the loaded config file.
+func projectBuilder() (*Builder, error) {
+	p, err := loadProject()
+	if err != nil {
+		return nil, err
+	}
+	src, err := p.Source()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := projectOverrides()
+	if err != nil {
+		return nil, err
+	}
+	components := NewCacheComponentResolver(p.ReadFile)
+	components.Globber = p.Glob
+	b := NewBuilder(src, components, p.Open)
+	b.Overrides = overrides
+	return b, nil
+}
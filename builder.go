@@ -1,12 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"reflect"
+	"strings"
 )
 
 type ResourceConfig struct {
 	Template  string
 	Output    string
+	Escape    Escaping
+	Delims    Delimiters
 	Inherits  []string
 	Variables VariableMap
 }
@@ -32,6 +38,10 @@ func (r *ResourceConfig) Is(r2 *ResourceConfig) bool {
 		return false
 	} else if r.Output != r2.Output {
 		return false
+	} else if r.Escape != r2.Escape {
+		return false
+	} else if r.Delims != r2.Delims {
+		return false
 	} else if !stringArrayIs(r.Inherits, r2.Inherits) {
 		return false
 	} else if !reflect.DeepEqual(r.Variables, r2.Variables) {
@@ -42,9 +52,242 @@ func (r *ResourceConfig) Is(r2 *ResourceConfig) bool {
 
 type ResourceConfigSource interface {
 	GlobalVariables() VariableMap
+	// GlobalDelimiters returns the delimiters of resources that don't set
+	// their own.
+	GlobalDelimiters() Delimiters
+	// GlobalMerge returns how variables are merged with those inherited from
+	// parents and from the globals.
+	GlobalMerge() MergeOptions
 	GetConfig(resource string) ResourceConfig
+	Resources() []string
 }
 
 type Resource struct {
 	Name string
 }
+
+// TrackingConfigSource records every resource whose config is read through it.
+type TrackingConfigSource struct {
+	Downstream ResourceConfigSource
+	hits       map[string]struct{}
+}
+
+func NewTrackingConfigSource(downstream ResourceConfigSource) *TrackingConfigSource {
+	return &TrackingConfigSource{
+		Downstream: downstream,
+		hits:       make(map[string]struct{}),
+	}
+}
+
+func (s *TrackingConfigSource) GlobalVariables() VariableMap {
+	return s.Downstream.GlobalVariables()
+}
+
+func (s *TrackingConfigSource) GlobalDelimiters() Delimiters {
+	return s.Downstream.GlobalDelimiters()
+}
+
+func (s *TrackingConfigSource) GlobalMerge() MergeOptions {
+	return s.Downstream.GlobalMerge()
+}
+
+func (s *TrackingConfigSource) GetConfig(resource string) ResourceConfig {
+	s.hits[resource] = struct{}{}
+	return s.Downstream.GetConfig(resource)
+}
+
+func (s *TrackingConfigSource) Resources() []string {
+	return s.Downstream.Resources()
+}
+
+func (s *TrackingConfigSource) Hits() map[string]struct{} {
+	return s.hits
+}
+
+var ErrNoOutput = errors.New("resource has no output")
+
+type CyclicalImportError struct {
+	Stack []string
+}
+
+func (c *CyclicalImportError) Error() string {
+	return "Cycle detected in import path: " + strings.Join(c.Stack, " -> ")
+}
+
+// ResourceImporter imports resources by rendering them with their own
+// effective variables straight into the writer of the importing scope.
+type ResourceImporter struct {
+	Builder *Builder
+	W       io.Writer
+	Stack   []string
+}
+
+func (i *ResourceImporter) Import(resource string, args ...interface{}) error {
+	return i.ImportTo(i.W, resource, args...)
+}
+
+// ImportTo imports a resource into w rather than W.
+func (i *ResourceImporter) ImportTo(w io.Writer, resource string, args ...interface{}) error {
+	for _, v := range i.Stack {
+		if v == resource {
+			return &CyclicalImportError{
+				Stack: append(i.Stack[:len(i.Stack):len(i.Stack)], resource),
+			}
+		}
+	}
+
+	cfg, err := i.Builder.Config(resource)
+	if err != nil {
+		return err
+	}
+
+	return i.Builder.render(w, i.Stack, resource, cfg, args...)
+}
+
+// OutputOpener opens the destination that a rendered resource is written to.
+type OutputOpener func(path string) (io.WriteCloser, error)
+
+type Builder struct {
+	Source     ResourceConfigSource
+	Components ComponentResolver
+	Open       OutputOpener
+	// Overrides, if set, are merged over the variables of every resource.
+	Overrides *Overrides
+}
+
+func NewBuilder(source ResourceConfigSource, components ComponentResolver, open OutputOpener) *Builder {
+	return &Builder{
+		Source:     source,
+		Components: components,
+		Open:       open,
+	}
+}
+
+// Variables returns the variables a resource is rendered with - its own
+// variables, merged with the globals as the parent and with the overrides
+// over them, with unset keys and replace markers taken out and ${name}
+// references expanded.
+func (b *Builder) Variables(cfg ResourceConfig) (VariableMap, error) {
+	vars, err := b.mergedVariables(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return vars.Interpolate()
+}
+
+func (b *Builder) mergedVariables(cfg ResourceConfig) (VariableMap, error) {
+	vars, err := cfg.Variables.Copy().MergeWith(b.Source.GlobalVariables().Copy(), b.Source.GlobalMerge())
+	if err != nil {
+		return nil, err
+	}
+	if vars, _, err = b.Overrides.merge(vars, nil); err != nil {
+		return nil, err
+	}
+	return vars.StripMarkers(), nil
+}
+
+// Config returns the effective config of a resource, with its Inherits chain
+// resolved and ${name} references in its Template and Output expanded against
+// the variables it is rendered with. Its Variables are left as they are.
+func (b *Builder) Config(resource string) (ResourceConfig, error) {
+	cfg, err := NewInheritanceResolver(b.Source).Resolve(resource)
+	// resources that never reference variables shouldn't fail on them
+	if err != nil || !strings.Contains(cfg.Template+cfg.Output, "${") {
+		return cfg, err
+	}
+
+	vars, err := b.mergedVariables(cfg)
+	if err != nil {
+		return ResourceConfig{}, err
+	}
+	in := newInterpolator(vars)
+	if cfg.Template, err = in.String("template", cfg.Template); err != nil {
+		return ResourceConfig{}, err
+	}
+	if cfg.Output, err = in.String("output", cfg.Output); err != nil {
+		return ResourceConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (b *Builder) Render(w io.Writer, resource string) error {
+	cfg, err := b.Config(resource)
+	if err != nil {
+		return err
+	}
+	return b.render(w, []string{}, resource, cfg)
+}
+
+// render renders a resource, where stack is the chain of resources that
+// imported it.
+func (b *Builder) render(w io.Writer, stack []string, resource string, cfg ResourceConfig, args ...interface{}) error {
+	importer := &ResourceImporter{
+		Builder: b,
+		W:       w,
+		Stack:   append(stack[:len(stack):len(stack)], resource),
+	}
+	vars, err := b.Variables(cfg)
+	if err != nil {
+		return err
+	}
+	scope := NewRenderScope(w, b.Components, importer, "", vars)
+	scope.Escape = cfg.Escape
+	scope.Delimiters = cfg.Delims
+	return scope.Render(cfg.Template, args...)
+}
+
+// Build renders a single resource and writes it to its output. Nothing is
+// written if rendering fails.
+func (b *Builder) Build(resource string) (err error) {
+	cfg, err := b.Config(resource)
+	if err != nil {
+		return
+	}
+
+	if cfg.Output == "" {
+		return ErrNoOutput
+	}
+
+	buf := new(bytes.Buffer)
+	err = b.render(buf, []string{}, resource, cfg)
+	if err != nil {
+		return
+	}
+
+	w, err := b.Open(cfg.Output)
+	if err != nil {
+		return
+	}
+
+	_, err = buf.WriteTo(w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+type BuildResult struct {
+	Resource string
+	Err      error
+}
+
+// BuildAll builds every resource in the source, continuing past failures.
+// Resources without an output, such as those that only exist to be inherited
+// from, are skipped.
+func (b *Builder) BuildAll() []BuildResult {
+	results := make([]BuildResult, 0)
+	for _, r := range b.Source.Resources() {
+		cfg, err := b.Config(r)
+		if err == nil && cfg.Output == "" {
+			continue
+		}
+		if err == nil {
+			err = b.Build(r)
+		}
+		results = append(results, BuildResult{
+			Resource: r,
+			Err:      err,
+		})
+	}
+	return results
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -13,12 +17,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{},
 		},
@@ -28,12 +36,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{},
 		},
@@ -43,12 +55,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": "a"},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": "a"},
 		},
@@ -58,12 +74,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -73,12 +93,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"b",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -88,12 +112,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"c",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -103,12 +131,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -118,12 +150,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -133,12 +169,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"b"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -148,12 +188,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"a", "b"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{"b", "a"},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
@@ -163,12 +207,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "a"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "b"}},
 		},
@@ -178,12 +226,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": "a"},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "b"}},
 		},
@@ -193,12 +245,16 @@ var resourceConfigEqualTests = []struct {
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": map[string]string{"a": "b"}},
 		},
 		ResourceConfig{
 			"a",
 			"b",
+			"",
+			Delimiters{},
 			[]string{},
 			VariableMap{"a": "a"},
 		},
@@ -232,3 +288,327 @@ func TestResourceConfigIsSamePasses(t *testing.T) {
 		t.Errorf("equality against self failed")
 	}
 }
+
+type mapConfigSource struct {
+	globals   VariableMap
+	delims    Delimiters
+	merge     MergeOptions
+	resources map[string]ResourceConfig
+}
+
+func (m *mapConfigSource) GlobalVariables() VariableMap {
+	return m.globals
+}
+
+func (m *mapConfigSource) GlobalDelimiters() Delimiters {
+	return m.delims
+}
+
+func (m *mapConfigSource) GlobalMerge() MergeOptions {
+	return m.merge
+}
+
+func (m *mapConfigSource) GetConfig(resource string) ResourceConfig {
+	return m.resources[resource]
+}
+
+func (m *mapConfigSource) Resources() []string {
+	resources := make([]string, 0, len(m.resources))
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, h := m.resources[k]; h {
+			resources = append(resources, k)
+		}
+	}
+	return resources
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type memoryOutputs map[string]*bytes.Buffer
+
+func (m memoryOutputs) Open(path string) (io.WriteCloser, error) {
+	b := new(bytes.Buffer)
+	m[path] = b
+	return nopWriteCloser{b}, nil
+}
+
+func TestBuilderVariables(t *testing.T) {
+	globals := VariableMap{"a": "global", "b": "global", "n": map[string]string{"x": "global", "y": "global"}}
+	src := &mapConfigSource{globals: globals}
+	b := NewBuilder(src, staticResolver{}, nil)
+
+	cfg := ResourceConfig{Variables: VariableMap{"a": "resource", "n": map[string]string{"x": "resource"}}}
+	vars, err := b.Variables(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := VariableMap{"a": "resource", "b": "global", "n": map[string]string{"x": "resource", "y": "global"}}
+	if !(&ResourceConfig{Variables: vars}).Is(&ResourceConfig{Variables: expected}) {
+		t.Fatalf("incorrect variables - expected %v, got %v", expected, vars)
+	}
+
+	if _, h := cfg.Variables["n"].(map[string]string)["y"]; h {
+		t.Errorf("resource variables were modified by merging globals")
+	}
+}
+
+func TestBuilderBuildAll(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"who": "world"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "out/a", Variables: VariableMap{}},
+			"b": {Template: "b.tpl", Output: "out/b", Variables: VariableMap{"who": "b"}},
+			"c": {Template: "missing.tpl", Output: "out/c", Variables: VariableMap{}},
+			"d": {Template: "a.tpl", Variables: VariableMap{}},
+		},
+	}
+	components := staticResolver{
+		"a.tpl": "hello {{ .Vars.who }}",
+		"b.tpl": "{{ include \"a.tpl\" }}!",
+	}
+	outputs := make(memoryOutputs)
+
+	results := NewBuilder(src, components, outputs.Open).BuildAll()
+	if len(results) != 3 {
+		t.Fatalf("incorrect number of results - expected %d, got %d", 3, len(results))
+	}
+
+	for _, r := range results[:2] {
+		if r.Err != nil {
+			t.Errorf("unexpected error building %s: %s", r.Resource, r.Err)
+		}
+	}
+	if results[2].Resource != "c" || results[2].Err != notExist {
+		t.Errorf("incorrect result for c - expected %v, got %v", notExist, results[2].Err)
+	}
+
+	if outputs["out/a"].String() != "hello world" {
+		t.Errorf("incorrect output for a - expected %s, got %s", "hello world", outputs["out/a"])
+	}
+	if outputs["out/b"].String() != "hello b!" {
+		t.Errorf("incorrect output for b - expected %s, got %s", "hello b!", outputs["out/b"])
+	}
+	if _, h := outputs["out/c"]; h {
+		t.Errorf("output was opened for failed resource c")
+	}
+}
+
+func TestBuilderBuildNoOutput(t *testing.T) {
+	src := &mapConfigSource{
+		globals:   VariableMap{},
+		resources: map[string]ResourceConfig{"a": {Template: "a.tpl"}},
+	}
+	outputs := make(memoryOutputs)
+
+	err := NewBuilder(src, staticResolver{"a.tpl": "a"}, outputs.Open).Build("a")
+	if err != ErrNoOutput {
+		t.Errorf("incorrect error - expected %v, got %v", ErrNoOutput, err)
+	}
+}
+
+func TestResourceImporter(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"who": "world"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "out/a", Variables: VariableMap{"who": "a"}},
+			"b": {Template: "b.tpl", Variables: VariableMap{"who": "b"}},
+			"c": {Template: "c.tpl", Variables: VariableMap{}},
+		},
+	}
+	components := staticResolver{
+		"a.tpl": "[{{ .Vars.who }} {{ import \"b\" \"arg\" }} {{ import \"c\" }}]",
+		"b.tpl": "({{ .Vars.who }} {{ .Arg0 }} {{ import \"c\" }})",
+		"c.tpl": "<{{ .Vars.who }}>",
+	}
+
+	b := new(bytes.Buffer)
+	err := NewBuilder(src, components, nil).Render(b, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "[a (b arg <world>) <world>]"
+	if b.String() != expected {
+		t.Errorf("incorrect result - expected %s, got %s", expected, b.String())
+	}
+}
+
+func TestCaptureImports(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"who": "world"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "out/a"},
+			"b": {Template: "b.tpl"},
+		},
+	}
+	components := staticResolver{
+		"a.tpl":    "{{ capture \"part.tpl\" | printf \"%q\" }}",
+		"part.tpl": "{{ .Vars.who }} {{ import \"b\" }}",
+		"b.tpl":    "b",
+	}
+
+	b := new(bytes.Buffer)
+	if err := NewBuilder(src, components, nil).Render(b, "a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "\"world b\""
+	if b.String() != expected {
+		t.Errorf("incorrect result - expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestResourceImporterErrors(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Variables: VariableMap{}},
+			"b": {Template: "b.tpl", Variables: VariableMap{}},
+			"c": {Template: "c.tpl", Variables: VariableMap{}},
+			"d": {Template: "d.tpl", Variables: VariableMap{}},
+		},
+	}
+	components := staticResolver{
+		"a.tpl": "{{ import \"b\" }}",
+		"b.tpl": "{{ import \"c\" }}",
+		"c.tpl": "{{ import \"a\" }}",
+		"d.tpl": "{{ import \"e\" }}",
+	}
+	builder := NewBuilder(src, components, nil)
+
+	err := builder.Render(new(bytes.Buffer), "a")
+	expected := "Cycle detected in import path: a -> b -> c -> a"
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("incorrect error - expected suffix %s, got %v", expected, err)
+	}
+
+	err = builder.Render(new(bytes.Buffer), "d")
+	expected = (&UnknownResourceError{Resource: "e"}).Error()
+	if err == nil || !strings.HasSuffix(err.Error(), expected) {
+		t.Errorf("incorrect error - expected suffix %s, got %v", expected, err)
+	}
+}
+
+func TestResourceImporterSharedTemplate(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "shared.tpl", Variables: VariableMap{"name": "a", "next": "b"}},
+			"b": {Template: "shared.tpl", Variables: VariableMap{"name": "b", "next": ""}},
+		},
+	}
+	components := NewCacheComponentResolver(func(filename string) ([]byte, error) {
+		return []byte("{{ .Vars.name }}{{ if .Vars.next }}({{ import .Vars.next }}){{ end }}"), nil
+	})
+
+	b := new(bytes.Buffer)
+	err := NewBuilder(src, components, nil).Render(b, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b.String() != "a(b)" {
+		t.Errorf("incorrect result - expected %s, got %s", "a(b)", b.String())
+	}
+}
+
+func TestBuilderMergeOptions(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"tags": []interface{}{"global"}, "title": "Global"},
+		merge: MergeOptions{
+			MergeStrategy: MergeStrategy{Lists: ListsAppend},
+			Paths:         map[string]MergeStrategy{"title": {Conflicts: ParentWins}},
+		},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "t.tpl", Variables: VariableMap{"tags": []interface{}{"a"}}},
+			"b": {Inherits: []string{"a"}, Variables: VariableMap{"tags": []interface{}{"b"}, "title": "B"}},
+		},
+	}
+	components := staticResolver{"t.tpl": "{{ .Vars.title }} {{ range .Vars.tags }}{{ . }} {{ end }}"}
+
+	b := new(bytes.Buffer)
+	if err := NewBuilder(src, components, nil).Render(b, "b"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b.String() != "Global global a b " {
+		t.Errorf("incorrect result - expected %q, got %q", "Global global a b ", b.String())
+	}
+}
+
+func TestBuilderVariableMarkers(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"footer": "global", "nav": map[string]interface{}{"home": "/", "links": []interface{}{"/a"}}},
+		resources: map[string]ResourceConfig{
+			"a": {Variables: VariableMap{"title": "a", "nav": map[string]interface{}{"about": "/about"}}},
+			"b": {Inherits: []string{"a"}, Variables: VariableMap{
+				"title":  UnsetMarker,
+				"footer": UnsetMarker,
+				"nav":    map[string]interface{}{ReplaceMarker: true, "links": []interface{}{"/b"}},
+			}},
+			"c": {Inherits: []string{"b"}, Variables: VariableMap{"footer": "c", "nav": map[string]interface{}{"extra": "/c"}}},
+		},
+	}
+	b := NewBuilder(src, staticResolver{}, nil)
+
+	for resource, expected := range map[string]VariableMap{
+		"a": {"title": "a", "footer": "global", "nav": map[string]interface{}{"home": "/", "about": "/about", "links": []interface{}{"/a"}}},
+		"b": {"nav": map[string]interface{}{"links": []interface{}{"/b"}}},
+		"c": {"footer": "c", "nav": map[string]interface{}{"links": []interface{}{"/b"}, "extra": "/c"}},
+	} {
+		cfg, err := b.Config(resource)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		vars, err := b.Variables(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(vars, expected) {
+			t.Errorf("incorrect variables for %s - expected %v, got %v", resource, expected, vars)
+		}
+	}
+}
+
+func TestBuilderInterpolation(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"host": "example.com"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "${kind}.tpl", Variables: VariableMap{"kind": "page", "url": "https://${host}/${name}"}},
+			"b": {Inherits: []string{"a"}, Output: "dist/${name}.html", Variables: VariableMap{"name": "b"}},
+			"c": {Output: "dist/${name}.html"},
+		},
+	}
+	components := staticResolver{"page.tpl": "{{ .Vars.url }}"}
+	b := NewBuilder(src, components, nil)
+
+	cfg, err := b.Config("b")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Template != "page.tpl" || cfg.Output != "dist/b.html" {
+		t.Errorf("incorrect paths - expected page.tpl and dist/b.html, got %s and %s", cfg.Template, cfg.Output)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := b.Render(buf, "b"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != "https://example.com/b" {
+		t.Errorf("incorrect result - expected %q, got %q", "https://example.com/b", buf.String())
+	}
+
+	// a only exists to be inherited from, so its own references can dangle
+	if _, err := b.Config("a"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	expected := `output references unknown variable "name"`
+	if _, err := b.Config("c"); err == nil || err.Error() != expected {
+		t.Errorf("incorrect error - expected %q, got %v", expected, err)
+	}
+}
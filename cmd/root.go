@@ -46,6 +46,13 @@ func Execute() {
 	}
 }
 
+// AddCommand registers subcommands on the root command. Commands that need the
+// templating types live alongside them in package main and register themselves
+// here from their init functions.
+func AddCommand(cmds ...*cobra.Command) {
+	rootCmd.AddCommand(cmds...)
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -71,8 +78,9 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. The notice goes to stderr so
+	// commands that print config, like show and vars, can be piped.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
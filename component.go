@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
 	"io"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -13,7 +19,21 @@ type RenderContext interface {
 	Resolve(base, relative string) (string, error)
 	Render(componentName string, args ...interface{}) (err error)
 	Import(componentName string, args ...interface{}) (err error)
+	// RenderIndented renders a component with every line of its output
+	// indented by indent spaces, bar the first unless first is set.
+	RenderIndented(indent int, first bool, componentName string, args ...interface{}) (err error)
+	// Capture renders a component and returns its output rather than
+	// writing it.
+	Capture(componentName string, args ...interface{}) (string, error)
 	Writer() io.Writer
+	// Column returns the column the output of the writer is currently at.
+	Column() int
+	// Escaping returns how component output is escaped.
+	Escaping() Escaping
+	// ReadFile reads a data file, given relative to the project.
+	ReadFile(path string) ([]byte, error)
+	// Glob returns the data files matching pattern, relative to the project.
+	Glob(pattern string) ([]string, error)
 	Vars() interface{}
 }
 
@@ -21,49 +41,285 @@ type FilesystemLoader interface {
 	Load(path string) (string, error)
 }
 
+// Component is a parsed template file. A component holds no render state of
+// its own, so one component can be rendered by any number of contexts at
+// once, including from inside its own render.
 type Component struct {
 	*template.Template
-	Filepath string
-
-	ctxMx sync.Locker
-	ctx   RenderContext
+	Filepath   string
+	Delimiters Delimiters
+	Meta       ComponentMeta
+
+	// lines taken off the top of the file before it was parsed
+	lineOffset int
+	// the header, read once by ParseComponentDelimited
+	header *componentHeader
 }
 
 func NewComponent(filepath string) *Component {
 	c := new(Component)
-	f := template.FuncMap{
+	c.Template = template.New("").Funcs(c.funcs(nil))
+	c.Filepath = filepath
+	return c
+}
+
+// funcs creates the template functions of the component bound to a context.
+// Templates are parsed with them bound to nothing, and then rebound for each
+// render.
+func (c *Component) funcs(ctx RenderContext) template.FuncMap {
+	funcs := template.FuncMap{
 		"include": func(component string, fargs ...interface{}) (interface{}, error) {
-			componentPath, err := c.ctx.Resolve(c.Filepath, component)
+			componentPath, err := ctx.Resolve(c.Filepath, component)
+			if err != nil {
+				return "", err
+			}
+
+			err = ctx.Render(componentPath, fargs...)
+			return "", err
+		},
+		"capture": func(component string, fargs ...interface{}) (interface{}, error) {
+			componentPath, err := ctx.Resolve(c.Filepath, component)
+			if err != nil {
+				return "", err
+			}
+
+			text, err := ctx.Capture(componentPath, fargs...)
+			if err != nil || c.escaping(ctx) != EscapeHTML {
+				return text, err
+			}
+			// already escaped as it was rendered
+			return htmltemplate.HTML(text), nil
+		},
+		"indentInclude": func(indent int, component string, fargs ...interface{}) (interface{}, error) {
+			if indent < 0 {
+				return "", fmt.Errorf("indentInclude: negative indent %d", indent)
+			}
+			componentPath, err := ctx.Resolve(c.Filepath, component)
+			if err != nil {
+				return "", err
+			}
+
+			err = ctx.RenderIndented(indent, true, componentPath, fargs...)
+			return "", err
+		},
+		"nestInclude": func(component string, fargs ...interface{}) (interface{}, error) {
+			componentPath, err := ctx.Resolve(c.Filepath, component)
 			if err != nil {
 				return "", err
 			}
 
-			err = c.ctx.Render(componentPath, fargs...)
+			err = ctx.RenderIndented(ctx.Column(), false, componentPath, fargs...)
 			return "", err
 		},
+		"readFile": func(file string) (string, error) {
+			path, err := ctx.Resolve(c.Filepath, file)
+			if err != nil {
+				return "", err
+			}
+
+			b, err := ctx.ReadFile(path)
+			return string(b), err
+		},
+		"loadData": func(file string) (interface{}, error) {
+			path, err := ctx.Resolve(c.Filepath, file)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := ctx.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return loadData(path, b)
+		},
+		"glob": func(pattern string) ([]string, error) {
+			resolved, err := ctx.Resolve(c.Filepath, pattern)
+			if err != nil {
+				return nil, err
+			}
+			dir, err := ctx.Resolve(c.Filepath, ".")
+			if err != nil {
+				return nil, err
+			}
+
+			matches, err := ctx.Glob(resolved)
+			if err != nil {
+				return nil, err
+			}
+			// matches are given back relative to the component, like the
+			// paths readFile and loadData take
+			for i, m := range matches {
+				if matches[i], err = filepath.Rel(dir, m); err != nil {
+					return nil, err
+				}
+			}
+			return matches, nil
+		},
 		"import": func(resource string, fargs ...interface{}) (interface{}, error) {
-			err := c.ctx.Import(resource, fargs...)
+			err := ctx.Import(resource, fargs...)
 			return "", err
 		},
 	}
+	for name, f := range standardFuncs {
+		funcs[name] = f
+	}
+	for name, f := range headerDirectives {
+		funcs[name] = f
+	}
+	return funcs
+}
 
-	c.Template = template.New("").Funcs(f)
-	c.Filepath = filepath
-	c.ctxMx = new(sync.Mutex)
-	return c
+// ComponentParseError is a syntax error in a component file. Col is the
+// column of the action the error was found in, or 0 if it couldn't be found.
+type ComponentParseError struct {
+	Filepath  string
+	Line, Col int
+	Msg       string
 }
 
-func (c *Component) Render(ctx RenderContext, args ...interface{}) (err error) {
+func (c *ComponentParseError) Error() string {
+	if c.Col == 0 {
+		return fmt.Sprintf("%s:%d: %s", c.Filepath, c.Line, c.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", c.Filepath, c.Line, c.Col, c.Msg)
+}
+
+// ParseComponent creates a component at filepath from its source text.
+func ParseComponent(filepath, text string) (*Component, error) {
+	return ParseComponentDelimited(filepath, text, Delimiters{})
+}
+
+// ParseComponentDelimited creates a component at filepath from its source
+// text, parsed with delims unless the component chooses its own.
+func ParseComponentDelimited(filepath, text string, delims Delimiters) (*Component, error) {
+	meta, body, offset, fmErr := frontMatter(text)
+	if fmErr != nil {
+		fmErr.Filepath = filepath
+		return nil, fmErr
+	}
+	text = body
+	if meta.Delims != (Delimiters{}) {
+		delims = meta.Delims
+	}
+
+	if offset == 0 {
+		d, body, found, err := componentDelimiters(text)
+		if err != nil {
+			return nil, &ComponentParseError{
+				Filepath: filepath,
+				Line:     1,
+				Msg:      err.Error(),
+			}
+		} else if found {
+			delims, text, offset = d, body, 1
+		}
+	}
+
+	create := func() *Component {
+		c := NewComponent(filepath)
+		c.Delimiters = delims
+		c.Meta = meta
+		c.lineOffset = offset
+		e := delims.effective()
+		c.Delims(e.Left, e.Right)
+		return c
+	}
+
+	c := create()
+	_, err := c.Parse(text)
+	if err == nil {
+		h, err := c.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		c.header = &h
+		return c, nil
+	}
+
+	parse := func(text string) error {
+		_, err := create().Parse(text)
+		return err
+	}
+
+	// text/template errors look like "template: <name>:<line>: <msg>"
+	pe := &ComponentParseError{
+		Filepath: filepath,
+		Msg:      err.Error(),
+	}
+	rest := strings.TrimPrefix(pe.Msg, "template: :")
+	if i := strings.Index(rest, ": "); i != -1 {
+		if line, lerr := strconv.Atoi(rest[:i]); lerr == nil {
+			pe.Line = line + offset
+			pe.Msg = rest[i+2:]
+			pe.Col = parseErrorColumn(text, line, err.Error(), delims.effective().Left, parse)
+		}
+	}
+	return nil, pe
+}
+
+// text/template only reports the line of a parse error, so find the column
+// by parsing ever longer prefixes of the line until the same error shows up,
+// then point at the start of the action that the error is in
+func parseErrorColumn(text string, line int, msg, left string, parse func(string) error) int {
+	start := 0
+	for i := 1; i < line; i++ {
+		n := strings.IndexByte(text[start:], '\n')
+		if n == -1 {
+			return 0
+		}
+		start += n + 1
+	}
+	end := len(text)
+	if n := strings.IndexByte(text[start:], '\n'); n != -1 {
+		end = start + n
+	}
+
+	for k := start + 1; k <= end; k++ {
+		err := parse(text[:k])
+		if err == nil || err.Error() != msg {
+			continue
+		}
+		if action := strings.LastIndex(text[start:k], left); action != -1 {
+			return action + 1
+		}
+		return k - start
+	}
+	return 0
+}
+
+// renderData creates the data a component is executed with.
+func (c *Component) renderData(ctx RenderContext, args []interface{}) (map[string]interface{}, error) {
+	vars, err := c.localVariables(ctx.Vars())
+	if err != nil {
+		return nil, err
+	}
+
 	v := make(map[string]interface{})
-	v["Vars"] = ctx.Vars()
+	v["Vars"] = vars
 	for i, arg := range args {
 		v["Arg"+strconv.Itoa(i)] = arg
 	}
 
-	c.ctxMx.Lock()
-	defer c.ctxMx.Unlock()
-	c.ctx = ctx
-	err = c.Execute(ctx.Writer(), v)
+	named, err := c.namedArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	v["Args"] = named
+	return v, nil
+}
+
+func (c *Component) Render(ctx RenderContext, args ...interface{}) (err error) {
+	v, err := c.renderData(ctx, args)
+	if err != nil {
+		return
+	}
+
+	t, err := c.Clone()
+	if err != nil {
+		return
+	}
+	funcs := c.funcs(ctx)
+	err = execute(ctx, c.escaping(ctx), t.Funcs(funcs), funcs, v)
 	return
 }
 
@@ -83,6 +339,13 @@ type ImportRenderer interface {
 	Import(path string, args ...interface{}) error
 }
 
+// WriterImportRenderer is an ImportRenderer that can render imports into a
+// given writer, so imports follow the scope's output when it is redirected.
+type WriterImportRenderer interface {
+	ImportRenderer
+	ImportTo(w io.Writer, path string, args ...interface{}) error
+}
+
 type RenderScope struct {
 	W                 io.Writer
 	ComponentResolver ComponentResolver
@@ -90,6 +353,10 @@ type RenderScope struct {
 	BasePath          string
 	Variables         interface{}
 	RenderStack       []*Component
+	Escape            Escaping
+	Delimiters        Delimiters
+
+	out *columnWriter
 }
 
 func NewRenderScope(w io.Writer, c ComponentResolver, r ImportRenderer, basePath string, vars interface{}) *RenderScope {
@@ -109,8 +376,20 @@ func (c *RenderScope) Resolve(base, relative string) (string, error) {
 	return filepath.Rel(c.BasePath, filepath.Join(dir, relative))
 }
 
+// resolve resolves a component parsed with the delimiters of the scope.
+func (c *RenderScope) resolve(path string) (*Component, error) {
+	if c.Delimiters == (Delimiters{}) {
+		return c.ComponentResolver.Resolve(path)
+	}
+	r, is := c.ComponentResolver.(DelimitedComponentResolver)
+	if !is {
+		return nil, ErrDelimitersUnsupported
+	}
+	return r.ResolveDelimited(path, c.Delimiters)
+}
+
 func (c *RenderScope) Render(componentName string, args ...interface{}) (err error) {
-	comp, err := c.ComponentResolver.Resolve(componentName)
+	comp, err := c.resolve(componentName)
 	if err != nil {
 		return err
 	}
@@ -129,12 +408,94 @@ func (c *RenderScope) Render(componentName string, args ...interface{}) (err err
 		}
 	}
 
+	// the stack only holds the active include path - siblings come and go
 	c.RenderStack = append(c.RenderStack, comp)
+	defer func() {
+		c.RenderStack = c.RenderStack[:len(c.RenderStack)-1]
+	}()
 
+	layouts, err := c.layouts(comp)
+	if err != nil {
+		return err
+	}
+	if len(layouts) > 0 {
+		return comp.RenderInLayouts(c, layouts, args...)
+	}
 	return comp.Render(c, args...)
 }
 
+// layouts resolves the chain of layouts a component extends, starting with
+// the one it extends directly.
+func (c *RenderScope) layouts(comp *Component) ([]*Component, error) {
+	layouts := make([]*Component, 0)
+	chain := []string{comp.Filepath}
+	for current := comp; ; {
+		extends, err := current.Extends()
+		if err != nil || extends == "" {
+			return layouts, err
+		}
+
+		path, err := c.Resolve(current.Filepath, extends)
+		if err != nil {
+			return nil, err
+		}
+		layout, err := c.resolve(path)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, layout.Filepath)
+		for _, v := range chain[:len(chain)-1] {
+			if v == layout.Filepath {
+				return nil, &CyclicalLayoutError{
+					Stack: chain,
+				}
+			}
+		}
+
+		layouts = append(layouts, layout)
+		current = layout
+	}
+}
+
+// RenderIndented captures the output of a component, then writes it out with
+// trailing newlines trimmed and its lines indented. Empty lines are left
+// empty.
+func (c *RenderScope) RenderIndented(indent int, first bool, componentName string, args ...interface{}) error {
+	text, err := c.Capture(componentName, args...)
+	if err != nil {
+		return err
+	}
+
+	text = strings.TrimRight(text, "\n")
+	_, err = io.WriteString(c.Writer(), indentLines(text, strings.Repeat(" ", indent), first))
+	return err
+}
+
+// Capture renders a component into a buffer through a child scope, which
+// shares the variables and the include path of this one.
+func (c *RenderScope) Capture(componentName string, args ...interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	child := &RenderScope{
+		W:                 buf,
+		ComponentResolver: c.ComponentResolver,
+		ResourceResolver:  c.ResourceResolver,
+		BasePath:          c.BasePath,
+		Variables:         c.Variables,
+		RenderStack:       c.RenderStack[:len(c.RenderStack):len(c.RenderStack)],
+		Escape:            c.Escape,
+		Delimiters:        c.Delimiters,
+	}
+	if err := child.Render(componentName, args...); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 func (c *RenderScope) Import(resource string, args ...interface{}) (err error) {
+	if r, is := c.ResourceResolver.(WriterImportRenderer); is {
+		return r.ImportTo(c.Writer(), resource, args...)
+	}
 	return c.ResourceResolver.Import(resource, args...)
 }
 
@@ -142,40 +503,149 @@ func (c *RenderScope) Vars() interface{} {
 	return c.Variables
 }
 
+// Writer returns where the component being rendered writes to - W, unless
+// the output has been redirected.
 func (c *RenderScope) Writer() io.Writer {
-	return c.W
+	if c.out == nil {
+		c.out = &columnWriter{w: c.W}
+	}
+	return c.out
+}
+
+func (c *RenderScope) Escaping() Escaping {
+	return c.Escape
+}
+
+func (c *RenderScope) Column() int {
+	c.Writer()
+	return c.out.col
 }
 
 type FileReader func(filename string) ([]byte, error)
 
+// Validator returns a stamp identifying the current version of a file.
+type Validator func(filename string) (string, error)
+
+// ModTimeValidator stamps files by their modification time and size.
+func ModTimeValidator(stat func(filename string) (os.FileInfo, error)) Validator {
+	return func(filename string) (string, error) {
+		fi, err := stat(filename)
+		if err != nil {
+			return "", err
+		}
+		return fi.ModTime().String() + "/" + strconv.FormatInt(fi.Size(), 10), nil
+	}
+}
+
+// ContentHashValidator stamps files by a hash of their contents.
+func ContentHashValidator(read FileReader) Validator {
+	return func(filename string) (string, error) {
+		b, err := read(filename)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:]), nil
+	}
+}
+
+type cachedComponent struct {
+	c     *Component
+	stamp string
+}
+
+type componentCacheKey struct {
+	path   string
+	delims Delimiters
+}
+
+// CacheComponentResolver parses each component once per choice of delimiters
+// and hands back the same *Component on every later resolve. Without a
+// Validate func a component is cached until it is invalidated, otherwise it
+// is reparsed whenever the stamp of its file changes. Data files are globbed
+// with Globber, if it is set.
 type CacheComponentResolver struct {
 	sync.Mutex
 	Downstream FileReader
-	cache      map[string]*Component
+	Validate   Validator
+	Globber    FileGlobber
+	cache      map[componentCacheKey]cachedComponent
 }
 
 func NewCacheComponentResolver(downstream FileReader) *CacheComponentResolver {
 	return &CacheComponentResolver{
 		Downstream: downstream,
-		cache:      make(map[string]*Component),
+		cache:      make(map[componentCacheKey]cachedComponent),
 	}
 }
 
 func (r *CacheComponentResolver) Resolve(path string) (*Component, error) {
+	return r.ResolveDelimited(path, Delimiters{})
+}
+
+func (r *CacheComponentResolver) ResolveDelimited(path string, delims Delimiters) (*Component, error) {
 	r.Lock()
 	defer r.Unlock()
 
-	c, h := r.cache[path]
-	if !h {
+	key := componentCacheKey{path, delims}
+	var stamp string
+	if r.Validate != nil {
+		var e error
+		stamp, e = r.Validate(path)
+		if e != nil {
+			delete(r.cache, key)
+			return nil, e
+		}
+	}
+
+	cc, h := r.cache[key]
+	if !h || cc.stamp != stamp {
 		b, e := r.Downstream(path)
 		if e != nil {
+			delete(r.cache, key)
 			return nil, e
 		}
-		c = NewComponent(path)
-		c.Parse(string(b))
-		r.cache[path] = c
+		c, e := ParseComponentDelimited(path, string(b), delims)
+		if e != nil {
+			delete(r.cache, key)
+			return nil, e
+		}
+		cc = cachedComponent{c, stamp}
+		r.cache[key] = cc
+	}
+	return cc.c, nil
+}
+
+// Invalidate drops the cached components for path, if there are any.
+func (r *CacheComponentResolver) Invalidate(path string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for key := range r.cache {
+		if key.path == path {
+			delete(r.cache, key)
+		}
 	}
-	return c, nil
+}
+
+// InvalidatePrefix drops every cached component whose path starts with prefix.
+func (r *CacheComponentResolver) InvalidatePrefix(prefix string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for key := range r.cache {
+		if strings.HasPrefix(key.path, prefix) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// Reset drops every cached component.
+func (r *CacheComponentResolver) Reset() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.cache = make(map[componentCacheKey]cachedComponent)
 }
 
 type TrackingComponentResolver struct {
@@ -190,13 +660,20 @@ func NewTrackingComponentResolver(downstream ComponentResolver) *TrackingCompone
 	}
 }
 
-func (r *TrackingComponentResolver) Resolve(path string) (c *Component, e error) {
-	c, e = r.Downstream.Resolve(path)
-	if e != nil {
-		return
+// Resolve tracks path even when it fails to resolve, so that fixing or
+// creating it is seen.
+func (r *TrackingComponentResolver) Resolve(path string) (*Component, error) {
+	r.hits[path] = struct{}{}
+	return r.Downstream.Resolve(path)
+}
+
+func (r *TrackingComponentResolver) ResolveDelimited(path string, delims Delimiters) (*Component, error) {
+	d, is := r.Downstream.(DelimitedComponentResolver)
+	if !is {
+		return nil, ErrDelimitersUnsupported
 	}
 	r.hits[path] = struct{}{}
-	return
+	return d.ResolveDelimited(path, delims)
 }
 
 func (r *TrackingComponentResolver) Hits() map[string]struct{} {
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type rfunc func(args ...interface{}) error
@@ -17,6 +20,7 @@ type testRCtx struct {
 	subimport    map[string]rfunc
 	vars         interface{}
 	resolveFails bool
+	escape       Escaping
 }
 
 var notExist = errors.New("does not exist")
@@ -46,10 +50,40 @@ func (c *testRCtx) Render(componentName string, args ...interface{}) error {
 	return f(args...)
 }
 
+func (c *testRCtx) RenderIndented(indent int, first bool, componentName string, args ...interface{}) error {
+	return c.Render(componentName, args...)
+}
+
+func (c *testRCtx) Capture(componentName string, args ...interface{}) (string, error) {
+	b := c.b
+	c.b = new(bytes.Buffer)
+	defer func() {
+		c.b = b
+	}()
+	err := c.Render(componentName, args...)
+	return c.b.String(), err
+}
+
 func (c *testRCtx) Writer() io.Writer {
 	return c.b
 }
 
+func (c *testRCtx) Escaping() Escaping {
+	return c.escape
+}
+
+func (c *testRCtx) Column() int {
+	return 0
+}
+
+func (c *testRCtx) ReadFile(path string) ([]byte, error) {
+	return nil, ErrDataFilesUnsupported
+}
+
+func (c *testRCtx) Glob(pattern string) ([]string, error) {
+	return nil, ErrDataFilesUnsupported
+}
+
 func (c *testRCtx) Vars() interface{} {
 	return c.vars
 }
@@ -60,16 +94,18 @@ var componentTests = []struct {
 	result       string
 	resultError  error
 }{
-	{"hello world", false, "hello world", nil},                            // simple
-	{"{{ .Vars.test }}", false, "hello!", nil},                            // var replacement
-	{"{{ .Arg0 }}, {{ .Arg1 }}", false, "the 0th arg, the 1st arg", nil},  // arg replacement
-	{"{{ include \"something\" }}", false, "something?", nil},             // inclusion of render
-	{"{{ include \"subargs\" 123 }}", false, "subargs test", nil},         // inclusion with specific arguments
-	{"{{ include \"nothing\" }}", true, "", resolveFail},                  // resolution failure
-	{"{{ include \"nonexistant\" }}", false, "", notExist},                // rendering failure
-	{"{{ import \"theimport\" }}", false, "renderedResult", nil},          // import of seperate render
-	{"{{ import \"theimportargs\" 132 }}", false, "someotherresult", nil}, // import of seperate render
-	{"{{ import \"badimport\" }}", false, "", notExist},                   // import failure
+	{"hello world", false, "hello world", nil},                                    // simple
+	{"{{ .Vars.test }}", false, "hello!", nil},                                    // var replacement
+	{"{{ .Arg0 }}, {{ .Arg1 }}", false, "the 0th arg, the 1st arg", nil},          // arg replacement
+	{"{{ include \"something\" }}", false, "something?", nil},                     // inclusion of render
+	{"{{ include \"subargs\" 123 }}", false, "subargs test", nil},                 // inclusion with specific arguments
+	{"{{ include \"nothing\" }}", true, "", resolveFail},                          // resolution failure
+	{"{{ include \"nonexistant\" }}", false, "", notExist},                        // rendering failure
+	{"{{ capture \"something\" | printf \"%q\" }}", false, "\"something?\"", nil}, // capture of render
+	{"{{ capture \"nonexistant\" }}", false, "", notExist},                        // capture failure
+	{"{{ import \"theimport\" }}", false, "renderedResult", nil},                  // import of seperate render
+	{"{{ import \"theimportargs\" 132 }}", false, "someotherresult", nil},         // import of seperate render
+	{"{{ import \"badimport\" }}", false, "", notExist},                           // import failure
 }
 
 func TestComponent(t *testing.T) {
@@ -268,6 +304,79 @@ func TestRenderScopeRenderRecursiveErrors(t *testing.T) {
 	}
 }
 
+var renderScopeIncludeGraphTests = []struct {
+	name       string
+	components map[string]string
+	expected   string
+	err        string
+}{
+	{"repeated", map[string]string{
+		"a":       "{{ include \"divider\" }}x{{ include \"divider\" }}",
+		"divider": "-",
+	}, "-x-", ""},
+	{"diamond", map[string]string{
+		"a": "{{ include \"b\" }}{{ include \"c\" }}",
+		"b": "b{{ include \"d\" }}",
+		"c": "c{{ include \"d\" }}",
+		"d": "d",
+	}, "bdcd", ""},
+	{"deep repeat", map[string]string{
+		"a": "{{ include \"b\" }}{{ include \"b\" }}",
+		"b": "{{ include \"c\" }}{{ include \"c\" }}",
+		"c": "c",
+	}, "cccc", ""},
+	{"cycle after sibling", map[string]string{
+		"a": "{{ include \"b\" }}{{ include \"c\" }}",
+		"b": "b",
+		"c": "{{ include \"b\" }}{{ include \"a\" }}",
+	}, "", "Cycle detected in include path: a -> c -> a"},
+	{"captured", map[string]string{
+		"a": "{{ capture \"b\" | len }}|{{ include \"c\" (capture \"b\") }}|{{ include \"b\" }}",
+		"b": "bb",
+		"c": "[{{ .Arg0 }}]",
+	}, "2|[bb]|bb", ""},
+	{"cycle through capture", map[string]string{
+		"a": "{{ include \"b\" }}",
+		"b": "{{ capture \"c\" }}",
+		"c": "{{ include \"a\" }}",
+	}, "", "Cycle detected in include path: a -> b -> c -> a"},
+}
+
+func TestRenderScopeIncludeGraphs(t *testing.T) {
+	for _, tt := range renderScopeIncludeGraphTests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := make(absoluteResolver)
+			for name, text := range tt.components {
+				c, err := ParseComponent(name, text)
+				if err != nil {
+					t.Fatalf("unexpected parse error: %s", err)
+				}
+				r[name] = c
+			}
+
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, r, staticResolver{}, "", struct{}{})
+			e := scope.Render("a")
+			if tt.err != "" {
+				if e == nil || !strings.HasSuffix(e.Error(), tt.err) {
+					t.Fatalf("unexpected error - expected %s, got %v", tt.err, e)
+				}
+				return
+			}
+			if e != nil {
+				t.Fatalf("unexpected render error: %s", e)
+			}
+
+			if b.String() != tt.expected {
+				t.Errorf("unexpected result - expected %s, got %s", tt.expected, b.String())
+			}
+			if len(scope.RenderStack) != 0 {
+				t.Errorf("render stack was not unwound, still holds %d components", len(scope.RenderStack))
+			}
+		})
+	}
+}
+
 type faultyResolver struct{}
 
 func (s faultyResolver) Resolve(path string) (*Component, error) {
@@ -358,8 +467,8 @@ var trackingComponentTests = []struct {
 }{
 	{[]string{"a"}, []string{"a"}},
 	{[]string{"a", "b"}, []string{"a", "b"}},
-	{[]string{"a", "e", "a"}, []string{"a"}},
-	{[]string{"e"}, []string{}},
+	{[]string{"a", "e", "a"}, []string{"a", "e"}},
+	{[]string{"e"}, []string{"e"}},
 }
 
 func in(test string, array []string) bool {
@@ -383,7 +492,7 @@ func TestTrackingComponentResolver(t *testing.T) {
 			r := NewTrackingComponentResolver(d)
 			for _, q := range tt.query {
 				_, e := r.Resolve(q)
-				if in(q, tt.hits) {
+				if _, h := d[q]; h {
 					if e != nil {
 						t.Errorf("query with hit returned error: %s", e)
 					}
@@ -409,3 +518,264 @@ func TestTrackingComponentResolver(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheComponentResolverInvalidation(t *testing.T) {
+	reads := make(map[string]int)
+	ccr := NewCacheComponentResolver(func(filename string) ([]byte, error) {
+		reads[filename]++
+		return []byte(filename), nil
+	})
+
+	resolveAll := func() {
+		for _, p := range []string{"a/x", "a/y", "b/x"} {
+			if _, err := ccr.Resolve(p); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+	expectReads := func(when string, expected map[string]int) {
+		if !reflect.DeepEqual(reads, expected) {
+			t.Errorf("%s: incorrect reads - expected %v, got %v", when, expected, reads)
+		}
+	}
+
+	resolveAll()
+	resolveAll()
+	expectReads("cached", map[string]int{"a/x": 1, "a/y": 1, "b/x": 1})
+
+	ccr.Invalidate("a/x")
+	ccr.Invalidate("nothing")
+	resolveAll()
+	expectReads("invalidate", map[string]int{"a/x": 2, "a/y": 1, "b/x": 1})
+
+	ccr.InvalidatePrefix("a/")
+	resolveAll()
+	expectReads("invalidate prefix", map[string]int{"a/x": 3, "a/y": 2, "b/x": 1})
+
+	ccr.Reset()
+	resolveAll()
+	expectReads("reset", map[string]int{"a/x": 4, "a/y": 3, "b/x": 2})
+}
+
+func TestCacheComponentResolverValidate(t *testing.T) {
+	files := map[string]string{"a": "first"}
+	read := func(filename string) ([]byte, error) {
+		v, h := files[filename]
+		if !h {
+			return nil, notExist
+		}
+		return []byte(v), nil
+	}
+
+	ccr := NewCacheComponentResolver(read)
+	ccr.Validate = ContentHashValidator(read)
+
+	render := func() string {
+		c, err := ccr.Resolve("a")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		b := new(bytes.Buffer)
+		c.Execute(b, nil)
+		return b.String()
+	}
+
+	a := render()
+	a1, _ := ccr.Resolve("a")
+	a2, _ := ccr.Resolve("a")
+	if a1 != a2 {
+		t.Errorf("unchanged component was reparsed")
+	}
+
+	files["a"] = "second"
+	if b := render(); a != "first" || b != "second" {
+		t.Errorf("changed component was not reparsed - expected %s, got %s", "second", b)
+	}
+
+	delete(files, "a")
+	if _, err := ccr.Resolve("a"); err != notExist {
+		t.Errorf("incorrect error for removed component - expected %v, got %v", notExist, err)
+	}
+}
+
+type stampedFileInfo struct {
+	os.FileInfo
+	mod  time.Time
+	size int64
+}
+
+func (s stampedFileInfo) ModTime() time.Time {
+	return s.mod
+}
+
+func (s stampedFileInfo) Size() int64 {
+	return s.size
+}
+
+func TestModTimeValidator(t *testing.T) {
+	info := stampedFileInfo{mod: time.Unix(100, 0), size: 10}
+	v := ModTimeValidator(func(filename string) (os.FileInfo, error) {
+		if filename != "a" {
+			return nil, notExist
+		}
+		return info, nil
+	})
+
+	first, err := v("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info.size = 11
+	second, _ := v("a")
+	info.mod = time.Unix(101, 0)
+	third, _ := v("a")
+	if first == second || second == third {
+		t.Errorf("stamp did not change with file - got %s, %s and %s", first, second, third)
+	}
+
+	if _, err := v("b"); err != notExist {
+		t.Errorf("incorrect error - expected %v, got %v", notExist, err)
+	}
+}
+
+var componentParseErrorTests = []struct {
+	template string
+	err      ComponentParseError
+}{
+	{"a\n{{ .x ", ComponentParseError{"c.tpl", 2, 1, "unclosed action"}},
+	{"hello {{ foo }}", ComponentParseError{"c.tpl", 1, 7, "function \"foo\" not defined"}},
+	{"x\n\n  {{ end }} {{ end }}", ComponentParseError{"c.tpl", 3, 3, "unexpected {{end}}"}},
+	{"{{ if .x }}\n{{ .y }} {{ if }}\n{{ end }}", ComponentParseError{"c.tpl", 2, 10, "missing value for if"}},
+}
+
+func TestParseComponentErrors(t *testing.T) {
+	for _, tt := range componentParseErrorTests {
+		t.Run(tt.template, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if c != nil {
+				t.Errorf("expected no component, got %v", c)
+			}
+			pe, is := err.(*ComponentParseError)
+			if !is {
+				t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+			}
+			if *pe != tt.err {
+				t.Errorf("incorrect error - expected %v, got %v", &tt.err, pe)
+			}
+		})
+	}
+}
+
+func TestComponentParseErrorMessage(t *testing.T) {
+	err := &ComponentParseError{"c.tpl", 2, 5, "unclosed action"}
+	if err.Error() != "c.tpl:2:5: unclosed action" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+	err.Col = 0
+	if err.Error() != "c.tpl:2: unclosed action" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
+
+func TestCacheComponentResolverParseErrors(t *testing.T) {
+	source := "{{ .x"
+	ccr := NewCacheComponentResolver(func(filename string) ([]byte, error) {
+		return []byte(source), nil
+	})
+
+	_, err := ccr.Resolve("a")
+	if _, is := err.(*ComponentParseError); !is {
+		t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+	}
+
+	source = "{{ .x }}"
+	c, err := ccr.Resolve("a")
+	if err != nil {
+		t.Fatalf("fixed component still failed to resolve: %s", err)
+	}
+	if c == nil {
+		t.Fatalf("unexpected nil component")
+	}
+}
+
+func TestComponentRenderConcurrent(t *testing.T) {
+	ccr := NewCacheComponentResolver(func(filename string) ([]byte, error) {
+		switch filename {
+		case "page":
+			return []byte("{{ include \"header\" .Vars.n }} body {{ .Vars.n }}"), nil
+		case "header":
+			return []byte("header {{ .Arg0 }}"), nil
+		}
+		return nil, notExist
+	})
+
+	const renders = 32
+	errs := make(chan error, renders)
+	for i := 0; i < renders; i++ {
+		go func(n int) {
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, ccr, staticResolver{}, "", map[string]int{"n": n})
+			if err := scope.Render("page"); err != nil {
+				errs <- err
+				return
+			}
+
+			expected := "header " + strconv.Itoa(n) + " body " + strconv.Itoa(n)
+			if b.String() != expected {
+				errs <- errors.New("expected " + expected + ", got " + b.String())
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+
+	for i := 0; i < renders; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("renders did not finish")
+		}
+	}
+}
+
+func TestComponentRenderReentrant(t *testing.T) {
+	c, err := ParseComponent("self", "[{{ .Vars.test }}{{ include \"self\" }}]")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	outer := &testRCtx{b: new(bytes.Buffer), vars: map[string]string{"test": "outer"}}
+	inner := &testRCtx{b: outer.b, vars: map[string]string{"test": "inner"}}
+	outer.subrender = map[string]rfunc{
+		"self": func(_ ...interface{}) error {
+			return c.Render(inner)
+		},
+	}
+	inner.subrender = map[string]rfunc{
+		"self": func(_ ...interface{}) error {
+			return nil
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Render(outer)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("render of component from inside itself deadlocked")
+	}
+
+	if outer.b.String() != "[outer[inner]]" {
+		t.Errorf("incorrect result - expected %s, got %s", "[outer[inner]]", outer.b.String())
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+)
+
+// ConfigError is a problem with a config file, pointing at the location of
+// the offending entry where one is known.
+type ConfigError struct {
+	File      string
+	Line, Col int
+	Msg       string
+}
+
+func (c *ConfigError) Error() string {
+	if c.Line == 0 {
+		return c.File + ": " + c.Msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", c.File, c.Line, c.Col, c.Msg)
+}
+
+// TomlConfigSource is a resource config source read from a TOML file, with
+// global variables in a [globals] table and each resource in a
+// [resources.<name>] table. The [merge] table sets the MergeOptions that
+// variables are merged with, with the strategies of particular key paths in
+// [merge.paths]. A variable set to "!unset" removes what a resource inherits
+// under its key, and a table with "!replace" = true replaces the table it
+// inherits rather than being merged with it. Variables, template and output
+// can reference variables by key path as ${name}, expanded once they are all
+// merged:
+//
+//	delims = ["{{", "}}"]
+//
+//	[merge]
+//	conflicts = "child"
+//	maps = "deep"
+//	lists = "append"
+//
+//	[merge.paths]
+//	"nav.links" = { lists = "union" }
+//
+//	[globals]
+//	domain = "example.com"
+//
+//	[resources.index]
+//	template = "templates/index.tpl"
+//	output = "dist/${slug}.html"
+//	escape = "html"
+//	delims = ["[[", "]]"]
+//	inherits = ["base"]
+//
+//	[resources.index.variables]
+//	title = "Home"
+//	slug = "index"
+//	url = "https://${domain}/${slug}.html"
+//	footer = "!unset"
+//
+//	[resources.index.variables.nav]
+//	"!replace" = true
+//	links = ["/"]
+//
+// It also knows the line and column each variable was set at, as Provenance.
+type TomlConfigSource struct {
+	File       string
+	globals    VariableMap
+	delims     Delimiters
+	merge      MergeOptions
+	resources  map[string]ResourceConfig
+	globalProv Provenance
+	prov       map[string]Provenance
+}
+
+func LoadTomlConfigSource(file string) (*TomlConfigSource, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTomlConfigSource(file, b)
+}
+
+// ParseTomlConfigSource parses TOML config, using file only to label errors.
+func ParseTomlConfigSource(file string, b []byte) (*TomlConfigSource, error) {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		e := &ConfigError{File: file}
+		e.Line, e.Col, e.Msg = tomlError(err)
+		return nil, e
+	}
+
+	s := &TomlConfigSource{
+		File:       file,
+		globals:    VariableMap{},
+		resources:  make(map[string]ResourceConfig),
+		globalProv: Provenance{},
+		prov:       make(map[string]Provenance),
+	}
+
+	errAt := func(keys []string, format string, args ...interface{}) error {
+		pos := tree.GetPositionPath(keys)
+		return &ConfigError{
+			File: file,
+			Line: pos.Line,
+			Col:  pos.Col,
+			Msg:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	for _, k := range tree.Keys() {
+		if k != "globals" && k != "resources" && k != "delims" && k != "merge" {
+			return nil, errAt([]string{k}, "unknown top level key %q", k)
+		}
+	}
+
+	if tree.Has("delims") {
+		d, is := tomlDelimiters(tree.Get("delims"))
+		if !is {
+			return nil, errAt([]string{"delims"}, "delims must be an array of two non-empty strings")
+		}
+		s.delims = d
+	}
+
+	if tree.Has("merge") {
+		merge, is := tree.Get("merge").(*toml.Tree)
+		if !is {
+			return nil, errAt([]string{"merge"}, "merge must be a table")
+		}
+		for _, k := range merge.Keys() {
+			keyPath := []string{"merge", k}
+			switch v := merge.GetPath([]string{k}); k {
+			case "conflicts", "maps", "lists":
+				if msg := tomlMergePolicy(&s.merge.MergeStrategy, k, v); msg != "" {
+					return nil, errAt(keyPath, "%s of merge %s", k, msg)
+				}
+			case "paths":
+				paths, is := v.(*toml.Tree)
+				if !is {
+					return nil, errAt(keyPath, "paths of merge must be a table")
+				}
+				s.merge.Paths = make(map[string]MergeStrategy)
+				// quoted key paths have no position of their own
+				if msg := tomlMergePaths(s.merge.Paths, "", paths); msg != "" {
+					return nil, errAt(keyPath, "%s", msg)
+				}
+			default:
+				return nil, errAt(keyPath, "unknown key %q in merge - expected one of conflicts, maps, lists, paths", k)
+			}
+		}
+	}
+
+	if tree.Has("globals") {
+		globals, is := tree.Get("globals").(*toml.Tree)
+		if !is {
+			return nil, errAt([]string{"globals"}, "globals must be a table")
+		}
+		s.globals = VariableMap(globals.ToMap())
+		s.globalProv = tomlProvenance(tree, []string{"globals"}, s.globals, Origin{Kind: OriginGlobal, File: file})
+	}
+
+	if !tree.Has("resources") {
+		return s, nil
+	}
+	resources, is := tree.Get("resources").(*toml.Tree)
+	if !is {
+		return nil, errAt([]string{"resources"}, "resources must be a table")
+	}
+
+	for _, name := range resources.Keys() {
+		path := []string{"resources", name}
+		res, is := resources.Get(name).(*toml.Tree)
+		if !is {
+			return nil, errAt(path, "resource %q must be a table", name)
+		}
+
+		cfg := ResourceConfig{
+			Inherits:  []string{},
+			Variables: VariableMap{},
+		}
+		for _, k := range res.Keys() {
+			keyPath := []string{"resources", name, k}
+			switch v := res.Get(k); k {
+			case "template", "output":
+				str, is := v.(string)
+				if !is {
+					return nil, errAt(keyPath, "%s of resource %q must be a string", k, name)
+				}
+				if k == "template" {
+					cfg.Template = str
+				} else {
+					cfg.Output = str
+				}
+			case "escape":
+				str, is := v.(string)
+				if !is || (Escaping(str) != EscapeText && Escaping(str) != EscapeHTML) {
+					return nil, errAt(keyPath, "escape of resource %q must be %q or %q", name, EscapeText, EscapeHTML)
+				}
+				cfg.Escape = Escaping(str)
+			case "delims":
+				d, is := tomlDelimiters(v)
+				if !is {
+					return nil, errAt(keyPath, "delims of resource %q must be an array of two non-empty strings", name)
+				}
+				cfg.Delims = d
+			case "inherits":
+				parents, is := v.([]interface{})
+				if !is {
+					return nil, errAt(keyPath, "inherits of resource %q must be an array of strings", name)
+				}
+				for _, p := range parents {
+					str, is := p.(string)
+					if !is {
+						return nil, errAt(keyPath, "inherits of resource %q must be an array of strings", name)
+					}
+					cfg.Inherits = append(cfg.Inherits, str)
+				}
+			case "variables":
+				vars, is := v.(*toml.Tree)
+				if !is {
+					return nil, errAt(keyPath, "variables of resource %q must be a table", name)
+				}
+				cfg.Variables = VariableMap(vars.ToMap())
+				s.prov[name] = tomlProvenance(tree, keyPath, cfg.Variables, Origin{Kind: OriginResource, Name: name, File: file})
+			default:
+				return nil, errAt(keyPath, "unknown key %q in resource %q - expected one of template, output, escape, delims, inherits, variables", k, name)
+			}
+		}
+		s.resources[name] = cfg
+	}
+
+	return s, nil
+}
+
+// tomlError splits the position go-toml prefixes its messages with, as
+// "(line, col): ", from the rest of the message. The position is 0, 0 when
+// there is none.
+func tomlError(err error) (line, col int, msg string) {
+	msg = err.Error()
+	if _, serr := fmt.Sscanf(msg, "(%d, %d): ", &line, &col); serr != nil {
+		return 0, 0, msg
+	}
+	return line, col, msg[strings.Index(msg, "): ")+3:]
+}
+
+func (s *TomlConfigSource) GlobalVariables() VariableMap {
+	return s.globals
+}
+
+func (s *TomlConfigSource) GlobalDelimiters() Delimiters {
+	return s.delims
+}
+
+func tomlDelimiters(v interface{}) (Delimiters, bool) {
+	pair, is := v.([]interface{})
+	if !is || len(pair) != 2 {
+		return Delimiters{}, false
+	}
+	left, lis := pair[0].(string)
+	right, ris := pair[1].(string)
+	if !lis || !ris || left == "" || right == "" {
+		return Delimiters{}, false
+	}
+	return Delimiters{left, right}, true
+}
+
+func (s *TomlConfigSource) GlobalMerge() MergeOptions {
+	return s.merge
+}
+
+// tomlMergePolicy sets policy k of s from config, returning what is wrong with
+// the value, if anything.
+func tomlMergePolicy(s *MergeStrategy, k string, v interface{}) string {
+	str, _ := v.(string)
+	switch k {
+	case "conflicts":
+		if p := ConflictPolicy(str); p != ChildWins && p != ParentWins {
+			return fmt.Sprintf("must be %q or %q", ChildWins, ParentWins)
+		}
+		s.Conflicts = ConflictPolicy(str)
+	case "maps":
+		if p := MapPolicy(str); p != MapsDeep && p != MapsReplace {
+			return fmt.Sprintf("must be %q or %q", MapsDeep, MapsReplace)
+		}
+		s.Maps = MapPolicy(str)
+	case "lists":
+		switch ListPolicy(str) {
+		case ListsReplace, ListsAppend, ListsPrepend, ListsUnion:
+		default:
+			return fmt.Sprintf("must be one of %q, %q, %q, %q", ListsReplace, ListsAppend, ListsPrepend, ListsUnion)
+		}
+		s.Lists = ListPolicy(str)
+	}
+	return ""
+}
+
+// tomlMergePaths reads the strategies of key paths beneath prefix, which can
+// be given as dotted keys, nested tables or both.
+func tomlMergePaths(paths map[string]MergeStrategy, prefix string, t *toml.Tree) string {
+	for _, k := range t.Keys() {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		v := t.GetPath([]string{k})
+		if sub, is := v.(*toml.Tree); is {
+			if msg := tomlMergePaths(paths, path, sub); msg != "" {
+				return msg
+			}
+			continue
+		}
+
+		if prefix == "" {
+			return fmt.Sprintf("merge path %q must be a table", k)
+		} else if k != "conflicts" && k != "maps" && k != "lists" {
+			return fmt.Sprintf("unknown key %q in merge path %q - expected one of conflicts, maps, lists", k, prefix)
+		}
+		s := paths[prefix]
+		if msg := tomlMergePolicy(&s, k, v); msg != "" {
+			return fmt.Sprintf("%s of merge path %q %s", k, prefix, msg)
+		}
+		paths[prefix] = s
+	}
+	return ""
+}
+
+// tomlProvenance gives each leaf of vars, the table at prefix in tree, the
+// origin o at the position it was set, or that of the nearest table above it
+// when go-toml has no position for the key itself.
+func tomlProvenance(tree *toml.Tree, prefix []string, vars VariableMap, o Origin) Provenance {
+	p := make(Provenance)
+	walkLeaves(reflect.ValueOf(vars), nil, func(keys []string, _ reflect.Value) {
+		at := o
+		path := append(prefix[:len(prefix):len(prefix)], keys...)
+		for i := len(path); i > 0 && at.Line == 0; i-- {
+			pos := tree.GetPositionPath(path[:i])
+			at.Line, at.Col = pos.Line, pos.Col
+		}
+		p[strings.Join(keys, ".")] = []Origin{at}
+	})
+	return p
+}
+
+func (s *TomlConfigSource) GlobalProvenance() Provenance {
+	return s.globalProv
+}
+
+func (s *TomlConfigSource) Provenance(resource string) Provenance {
+	return s.prov[resource]
+}
+
+func (s *TomlConfigSource) GetConfig(resource string) ResourceConfig {
+	return s.resources[resource]
+}
+
+func (s *TomlConfigSource) Resources() []string {
+	resources := make([]string, 0, len(s.resources))
+	for k := range s.resources {
+		resources = append(resources, k)
+	}
+	sort.Strings(resources)
+	return resources
+}
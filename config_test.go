@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const validTomlConfig = `
+delims = ["<%", "%>"]
+
+[merge]
+conflicts = "parent"
+lists = "append"
+
+[merge.paths]
+"nav.links" = { lists = "union" }
+
+[merge.paths.owner]
+maps = "replace"
+
+[merge.paths.owner.name]
+conflicts = "child"
+
+[globals]
+domain = "example.com"
+
+[globals.owner]
+name = "someone"
+
+[resources.base]
+template = "base.tpl"
+
+[resources.base.variables]
+title = "Base"
+
+[resources.index]
+template = "index.tpl"
+output = "dist/index.html"
+escape = "html"
+delims = ["[[", "]]"]
+inherits = ["base", "other"]
+
+[resources.index.variables]
+title = "Home"
+tags = ["a", "b"]
+`
+
+func TestTomlConfigSource(t *testing.T) {
+	s, err := ParseTomlConfigSource("resources.toml", []byte(validTomlConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(s.Resources(), []string{"base", "index"}) {
+		t.Errorf("incorrect resources - expected %v, got %v", []string{"base", "index"}, s.Resources())
+	}
+
+	globals := VariableMap{"domain": "example.com", "owner": map[string]interface{}{"name": "someone"}}
+	if !reflect.DeepEqual(s.GlobalVariables(), globals) {
+		t.Errorf("incorrect globals - expected %v, got %v", globals, s.GlobalVariables())
+	}
+	if s.GlobalDelimiters() != (Delimiters{"<%", "%>"}) {
+		t.Errorf("incorrect global delimiters - expected %v, got %v", Delimiters{"<%", "%>"}, s.GlobalDelimiters())
+	}
+
+	merge := MergeOptions{
+		MergeStrategy{Conflicts: ParentWins, Lists: ListsAppend},
+		map[string]MergeStrategy{
+			"nav.links":  {Lists: ListsUnion},
+			"owner":      {Maps: MapsReplace},
+			"owner.name": {Conflicts: ChildWins},
+		},
+	}
+	if !reflect.DeepEqual(s.GlobalMerge(), merge) {
+		t.Errorf("incorrect merge options - expected %v, got %v", merge, s.GlobalMerge())
+	}
+
+	expected := ResourceConfig{
+		"index.tpl",
+		"dist/index.html",
+		EscapeHTML,
+		Delimiters{"[[", "]]"},
+		[]string{"base", "other"},
+		VariableMap{"title": "Home", "tags": []interface{}{"a", "b"}},
+	}
+	index := s.GetConfig("index")
+	if !index.Is(&expected) {
+		t.Errorf("incorrect config for index - expected %v, got %v", expected, index)
+	}
+
+	expected = ResourceConfig{"base.tpl", "", "", Delimiters{}, []string{}, VariableMap{"title": "Base"}}
+	base := s.GetConfig("base")
+	if !base.Is(&expected) {
+		t.Errorf("incorrect config for base - expected %v, got %v", expected, base)
+	}
+}
+
+func TestTomlConfigSourceMarkers(t *testing.T) {
+	s, err := ParseTomlConfigSource("resources.toml", []byte(`
+[resources.a.variables]
+footer = "!unset"
+
+[resources.a.variables.nav]
+"!replace" = true
+links = ["/"]
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := VariableMap{
+		"footer": UnsetMarker,
+		"nav":    map[string]interface{}{ReplaceMarker: true, "links": []interface{}{"/"}},
+	}
+	if vars := s.GetConfig("a").Variables; !reflect.DeepEqual(vars, expected) {
+		t.Errorf("incorrect variables - expected %v, got %v", expected, vars)
+	}
+}
+
+func TestTomlConfigSourceEmpty(t *testing.T) {
+	s, err := ParseTomlConfigSource("resources.toml", []byte{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(s.Resources()) != 0 || len(s.GlobalVariables()) != 0 {
+		t.Errorf("expected no resources or globals, got %v and %v", s.Resources(), s.GlobalVariables())
+	}
+}
+
+var tomlConfigErrorTests = []struct {
+	name, config, err string
+}{
+	{"syntax", "[resources.a]\ntemplate = 1 2\n", "resources.toml:2:14: unexpected token"},
+	{"top level key", "something = 1\n", "resources.toml:1:1: unknown top level key \"something\""},
+	{"globals type", "globals = 1\n", "resources.toml:1:1: globals must be a table"},
+	{"resources type", "resources = \"a\"\n", "resources.toml:1:1: resources must be a table"},
+	{"resource type", "[resources]\na = 1\n", "resources.toml:2:1: resource \"a\" must be a table"},
+	{"template type", "[resources.a]\n\ntemplate = 1\n", "resources.toml:3:1: template of resource \"a\" must be a string"},
+	{"output type", "[resources.a]\noutput = true\n", "resources.toml:2:1: output of resource \"a\" must be a string"},
+	{"escape type", "[resources.a]\nescape = true\n", "resources.toml:2:1: escape of resource \"a\" must be \"text\" or \"html\""},
+	{"escape value", "[resources.a]\nescape = \"xml\"\n", "resources.toml:2:1: escape of resource \"a\" must be \"text\" or \"html\""},
+	{"delims type", "delims = \"[[\"\n", "resources.toml:1:1: delims must be an array of two non-empty strings"},
+	{"delims empty", "delims = [\"[[\", \"\"]\n", "resources.toml:1:1: delims must be an array of two non-empty strings"},
+	{"resource delims", "[resources.a]\ndelims = [\"[[\"]\n", "resources.toml:2:1: delims of resource \"a\" must be an array of two non-empty strings"},
+	{"merge type", "merge = 1\n", "resources.toml:1:1: merge must be a table"},
+	{"merge key", "[merge]\nlist = \"append\"\n", "resources.toml:2:1: unknown key \"list\" in merge - expected one of conflicts, maps, lists, paths"},
+	{"merge conflicts", "[merge]\nconflicts = \"mine\"\n", "resources.toml:2:1: conflicts of merge must be \"child\" or \"parent\""},
+	{"merge maps", "[merge]\nmaps = 1\n", "resources.toml:2:1: maps of merge must be \"deep\" or \"replace\""},
+	{"merge lists", "[merge]\nlists = \"concat\"\n", "resources.toml:2:1: lists of merge must be one of \"replace\", \"append\", \"prepend\", \"union\""},
+	{"merge paths type", "[merge]\npaths = []\n", "resources.toml:2:1: paths of merge must be a table"},
+	{"merge path type", "[merge.paths]\na = \"union\"\n", "resources.toml:1:1: merge path \"a\" must be a table"},
+	{"merge path key", "[merge.paths]\n\"a.b\" = { list = \"union\" }\n", "resources.toml:1:1: unknown key \"list\" in merge path \"a.b\""},
+	{"merge path value", "[merge.paths.a]\nlists = \"all\"\n", "resources.toml:1:1: lists of merge path \"a\" must be one of"},
+	{"inherits type", "[resources.a]\ninherits = \"b\"\n", "resources.toml:2:1: inherits of resource \"a\" must be an array of strings"},
+	{"inherits elements", "[resources.a]\ninherits = [1, 2]\n", "resources.toml:2:1: inherits of resource \"a\" must be an array of strings"},
+	{"variables type", "[resources.a]\nvariables = 1\n", "resources.toml:2:1: variables of resource \"a\" must be a table"},
+	{"unknown key", "[resources.a]\ntemplates = \"a\"\n", "resources.toml:2:1: unknown key \"templates\" in resource \"a\""},
+}
+
+func TestTomlConfigSourceErrors(t *testing.T) {
+	for _, tt := range tomlConfigErrorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTomlConfigSource("resources.toml", []byte(tt.config))
+			if err == nil {
+				t.Fatalf("expected an error, got nil")
+			}
+			if _, is := err.(*ConfigError); !is {
+				t.Errorf("expected a *ConfigError, got %T", err)
+			}
+			if len(err.Error()) < len(tt.err) || err.Error()[:len(tt.err)] != tt.err {
+				t.Errorf("unexpected error - expected prefix %s, got %s", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FileGlobber returns the files matching a pattern, as filepath.Glob does.
+type FileGlobber func(pattern string) ([]string, error)
+
+// DataFileResolver is a ComponentResolver that can also read the plain files
+// that templates load data from.
+type DataFileResolver interface {
+	ComponentResolver
+	ReadFile(path string) ([]byte, error)
+	Glob(pattern string) ([]string, error)
+}
+
+// ErrDataFilesUnsupported is returned when a template reads a data file
+// through a resolver that can't read them.
+var ErrDataFilesUnsupported = errors.New("component resolver does not support reading data files")
+
+// loadData decodes a data file by its extension. CSV files are loaded as a
+// list of maps keyed by the cells of their first row.
+func loadData(path string, b []byte) (interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return fromJSON(string(b))
+	case ".yaml", ".yml":
+		return fromYAML(string(b))
+	case ".toml":
+		return fromTOML(string(b))
+	case ".csv":
+		return fromCSV(string(b))
+	}
+	return nil, fmt.Errorf("cannot load data from %s - expected a .json, .yaml, .yml, .toml or .csv file", path)
+}
+
+// dataFiles returns the resolver data files are read through, checking that
+// path doesn't lead out of the project.
+func (c *RenderScope) dataFiles(path string) (DataFileResolver, error) {
+	if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) || filepath.IsAbs(path) {
+		return nil, fmt.Errorf("%s is outside of the project", path)
+	}
+	r, is := c.ComponentResolver.(DataFileResolver)
+	if !is {
+		return nil, ErrDataFilesUnsupported
+	}
+	return r, nil
+}
+
+func (c *RenderScope) ReadFile(path string) ([]byte, error) {
+	r, err := c.dataFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadFile(path)
+}
+
+func (c *RenderScope) Glob(pattern string) ([]string, error) {
+	r, err := c.dataFiles(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return r.Glob(pattern)
+}
+
+// ReadFile reads a data file straight from Downstream. Data files are never
+// cached, as nothing is parsed from them ahead of a render.
+func (r *CacheComponentResolver) ReadFile(path string) ([]byte, error) {
+	return r.Downstream(path)
+}
+
+func (r *CacheComponentResolver) Glob(pattern string) ([]string, error) {
+	if r.Globber == nil {
+		return nil, ErrDataFilesUnsupported
+	}
+	return r.Globber(pattern)
+}
+
+func (r *TrackingComponentResolver) ReadFile(path string) ([]byte, error) {
+	d, is := r.Downstream.(DataFileResolver)
+	if !is {
+		return nil, ErrDataFilesUnsupported
+	}
+	r.hits[path] = struct{}{}
+	return d.ReadFile(path)
+}
+
+// Glob tracks every file matching pattern, along with the directory the
+// pattern looks in when it is a single one, so files added to it are seen.
+func (r *TrackingComponentResolver) Glob(pattern string) ([]string, error) {
+	d, is := r.Downstream.(DataFileResolver)
+	if !is {
+		return nil, ErrDataFilesUnsupported
+	}
+	if dir := filepath.Dir(pattern); !strings.ContainsAny(dir, `*?[\`) {
+		r.hits[dir] = struct{}{}
+	}
+	matches, err := d.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		r.hits[m] = struct{}{}
+	}
+	return matches, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// a project of files held in memory, globbed like filepath.Glob
+type memoryFiles map[string]string
+
+func (m memoryFiles) ReadFile(filename string) ([]byte, error) {
+	v, h := m[filename]
+	if !h {
+		return nil, notExist
+	}
+	return []byte(v), nil
+}
+
+func (m memoryFiles) Glob(pattern string) ([]string, error) {
+	matches := make([]string, 0)
+	for name := range m {
+		if ok, err := path.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m memoryFiles) resolver() *CacheComponentResolver {
+	r := NewCacheComponentResolver(m.ReadFile)
+	r.Globber = m.Glob
+	return r
+}
+
+var loadDataTests = []struct {
+	path     string
+	data     string
+	expected interface{}
+}{
+	{"a.json", `{"name": "api", "port": 80}`, map[string]interface{}{"name": "api", "port": int64(80)}},
+	{"a.yaml", "name: api\nport: 80", map[string]interface{}{"name": "api", "port": int64(80)}},
+	{"a.YML", "- a\n- b", []interface{}{"a", "b"}},
+	{"a.toml", "name = \"api\"\nport = 80", map[string]interface{}{"name": "api", "port": int64(80)}},
+	{"a.csv", "name,port\napi,80\nweb,443\n", []interface{}{
+		map[string]interface{}{"name": "api", "port": "80"},
+		map[string]interface{}{"name": "web", "port": "443"},
+	}},
+	{"a.csv", "name,port\n", []interface{}{}},
+}
+
+func TestLoadData(t *testing.T) {
+	for _, tt := range loadDataTests {
+		t.Run(tt.path, func(t *testing.T) {
+			v, err := loadData(tt.path, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(v, tt.expected) {
+				t.Errorf("incorrect result - expected %#v, got %#v", tt.expected, v)
+			}
+		})
+	}
+}
+
+var loadDataErrorTests = []struct {
+	path string
+	data string
+	err  string
+}{
+	{"a.txt", "", "cannot load data from a.txt - expected a .json, .yaml, .yml, .toml or .csv file"},
+	{"a.json", "{", "unexpected EOF"},
+	{"a.toml", "a = ", "expecting a value"},
+	{"a.csv", "a,b\n1\n", "wrong number of fields"},
+}
+
+func TestLoadDataErrors(t *testing.T) {
+	for _, tt := range loadDataErrorTests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := loadData(tt.path, []byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("incorrect error - expected %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+var dataFiles = memoryFiles{
+	"page.tpl":              "{{ readFile \"data/note.txt\" }}{{ range loadData \"data/services.csv\" }} {{ .name }}{{ end }}",
+	"glob.tpl":              "{{ range glob \"data/*.json\" }}{{ . }}={{ (loadData .).port }} {{ end }}",
+	"site/nested.tpl":       "{{ range glob \"../data/*.json\" }}{{ . }} {{ end }}{{ readFile \"../data/note.txt\" }}",
+	"site/child.tpl":        "{{ extends \"../layouts/base.tpl\" }}{{ define \"content\" }}{{ readFile \"../data/note.txt\" }}{{ end }}",
+	"layouts/base.tpl":      "{{ readFile \"header.txt\" }}|{{ block \"content\" . }}{{ end }}",
+	"layouts/header.txt":    "header",
+	"outside.tpl":           "{{ readFile \"../secret\" }}",
+	"outside-glob.tpl":      "{{ glob \"../*\" }}",
+	"unknown.tpl":           "{{ loadData \"data/note.txt\" }}",
+	"data/note.txt":         "note",
+	"data/services.csv":     "name\napi\nweb\n",
+	"data/api.json":         `{"port": 80}`,
+	"data/web.json":         `{"port": 443}`,
+	"data/nested/skip.json": `{}`,
+}
+
+var dataFuncTests = []struct {
+	component string
+	expected  string
+}{
+	{"page.tpl", "note api web"},
+	{"glob.tpl", "data/api.json=80 data/web.json=443 "},
+	{"site/nested.tpl", "../data/api.json ../data/web.json note"},
+	{"site/child.tpl", "header|note"},
+}
+
+func TestRenderScopeDataFiles(t *testing.T) {
+	for _, tt := range dataFuncTests {
+		t.Run(tt.component, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, dataFiles.resolver(), staticResolver{}, "", struct{}{})
+			if err := scope.Render(tt.component); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("incorrect result - expected %q, got %q", tt.expected, b.String())
+			}
+		})
+	}
+}
+
+var dataFuncErrorTests = []struct {
+	component string
+	resolver  ComponentResolver
+	err       string
+}{
+	{"outside.tpl", dataFiles.resolver(), "../secret is outside of the project"},
+	{"outside-glob.tpl", dataFiles.resolver(), "../* is outside of the project"},
+	{"unknown.tpl", dataFiles.resolver(), "cannot load data from data/note.txt"},
+	{"glob.tpl", NewCacheComponentResolver(dataFiles.ReadFile), ErrDataFilesUnsupported.Error()},
+	{"page.tpl", staticResolver(dataFiles), ErrDataFilesUnsupported.Error()},
+}
+
+func TestRenderScopeDataFileErrors(t *testing.T) {
+	for _, tt := range dataFuncErrorTests {
+		t.Run(tt.component, func(t *testing.T) {
+			scope := NewRenderScope(new(bytes.Buffer), tt.resolver, staticResolver{}, "", struct{}{})
+			err := scope.Render(tt.component)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("incorrect error - expected %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestTrackingComponentResolverDataFiles(t *testing.T) {
+	r := NewTrackingComponentResolver(dataFiles.resolver())
+	scope := NewRenderScope(new(bytes.Buffer), r, staticResolver{}, "", struct{}{})
+	for _, component := range []string{"page.tpl", "glob.tpl"} {
+		if err := scope.Render(component); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	expected := map[string]struct{}{
+		"page.tpl":          {},
+		"glob.tpl":          {},
+		"data":              {},
+		"data/note.txt":     {},
+		"data/services.csv": {},
+		"data/api.json":     {},
+		"data/web.json":     {},
+	}
+	if !reflect.DeepEqual(r.Hits(), expected) {
+		t.Errorf("incorrect hits - expected %v, got %v", expected, r.Hits())
+	}
+
+	if _, err := NewTrackingComponentResolver(staticResolver{}).ReadFile("a"); err != ErrDataFilesUnsupported {
+		t.Errorf("incorrect error - expected %v, got %v", ErrDataFilesUnsupported, err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// Delimiters are the action delimiters a component is parsed with. The zero
+// value stands for text/template's own "{{" and "}}".
+type Delimiters struct {
+	Left, Right string
+}
+
+var defaultDelimiters = Delimiters{"{{", "}}"}
+
+// Or returns d, or fallback if d is the zero value.
+func (d Delimiters) Or(fallback Delimiters) Delimiters {
+	if d == (Delimiters{}) {
+		return fallback
+	}
+	return d
+}
+
+// effective returns the delimiters actually used by text/template.
+func (d Delimiters) effective() Delimiters {
+	return d.Or(defaultDelimiters)
+}
+
+func (d Delimiters) String() string {
+	e := d.effective()
+	return e.Left + " " + e.Right
+}
+
+// DelimitedComponentResolver is a ComponentResolver that can parse components
+// with delimiters other than the defaults.
+type DelimitedComponentResolver interface {
+	ComponentResolver
+	ResolveDelimited(path string, delims Delimiters) (*Component, error)
+}
+
+// ErrDelimitersUnsupported is returned when rendering with custom delimiters
+// through a resolver that can't parse with them.
+var ErrDelimitersUnsupported = errors.New("component resolver does not support custom delimiters")
+
+// a component can choose its own delimiters on its first line with
+//
+//	{{ delims "[[" "]]" }}
+//
+// which is always written with the default delimiters, whatever the resource
+// renders with
+var delimsDirective = regexp.MustCompile(`^[ \t]*\{\{-?[ \t]*delims[ \t]+("(?:[^"\\]|\\.)*")[ \t]+("(?:[^"\\]|\\.)*")[ \t]*-?\}\}[ \t]*(?:\r?\n|$)`)
+
+// componentDelimiters looks for a delims directive at the start of text,
+// returning the delimiters it sets and the text following it.
+func componentDelimiters(text string) (Delimiters, string, bool, error) {
+	m := delimsDirective.FindStringSubmatch(text)
+	if m == nil {
+		return Delimiters{}, text, false, nil
+	}
+
+	left, lerr := strconv.Unquote(m[1])
+	right, rerr := strconv.Unquote(m[2])
+	if lerr != nil || rerr != nil || left == "" || right == "" {
+		return Delimiters{}, text, true, errors.New("delims takes two non-empty strings")
+	}
+	return Delimiters{left, right}, text[len(m[0]):], true, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+var componentDelimitersTests = []struct {
+	text   string
+	delims Delimiters
+	body   string
+	found  bool
+	err    bool
+}{
+	{"no directive", Delimiters{}, "no directive", false, false},
+	{"{{ delims \"[[\" \"]]\" }}\nbody", Delimiters{"[[", "]]"}, "body", true, false},
+	{"{{- delims \"<%\" \"%>\" -}}\r\nbody", Delimiters{"<%", "%>"}, "body", true, false},
+	{"{{ delims \"[[\" \"]]\" }}", Delimiters{"[[", "]]"}, "", true, false},
+	{"{{delims \"\\\"\" \"}\"}}\nbody", Delimiters{"\"", "}"}, "body", true, false},
+	{"body\n{{ delims \"[[\" \"]]\" }}", Delimiters{}, "body\n{{ delims \"[[\" \"]]\" }}", false, false},
+	{"{{ delims \"[[\" \"]]\" }} body", Delimiters{}, "{{ delims \"[[\" \"]]\" }} body", false, false},
+	{"{{ delims \"\" \"]]\" }}\n", Delimiters{}, "", true, true},
+}
+
+func TestComponentDelimiters(t *testing.T) {
+	for _, tt := range componentDelimitersTests {
+		t.Run(tt.text, func(t *testing.T) {
+			delims, body, found, err := componentDelimiters(tt.text)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if delims != tt.delims || body != tt.body || found != tt.found {
+				t.Errorf("incorrect result - expected %v %q %v, got %v %q %v", tt.delims, tt.body, tt.found, delims, body, found)
+			}
+		})
+	}
+}
+
+var parseComponentDelimitedTests = []struct {
+	text     string
+	delims   Delimiters
+	expected Delimiters
+	result   string
+}{
+	{"{{ .Vars.v }} [[ x ]]", Delimiters{}, Delimiters{}, "value [[ x ]]"},
+	{"{{ .Vars.v }} [[ .Vars.v ]]", Delimiters{"[[", "]]"}, Delimiters{"[[", "]]"}, "{{ .Vars.v }} value"},
+	{"{{ delims \"<%\" \"%>\" }}\n${{ x }} <% .Vars.v %>", Delimiters{"[[", "]]"}, Delimiters{"<%", "%>"}, "${{ x }} value"},
+	{"{{ delims \"<%\" \"%>\" }}\n<% param \"a\" \"b\" %><% .Args.a %>", Delimiters{}, Delimiters{"<%", "%>"}, "b"},
+}
+
+func TestParseComponentDelimited(t *testing.T) {
+	for _, tt := range parseComponentDelimitedTests {
+		t.Run(tt.text, func(t *testing.T) {
+			c, err := ParseComponentDelimited("c.tpl", tt.text, tt.delims)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if c.Delimiters != tt.expected {
+				t.Errorf("incorrect delimiters - expected %v, got %v", tt.expected, c.Delimiters)
+			}
+
+			rctx := &testRCtx{b: new(bytes.Buffer), vars: map[string]string{"v": "value"}}
+			if err := c.Render(rctx); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rctx.b.String() != tt.result {
+				t.Errorf("incorrect result - expected %q, got %q", tt.result, rctx.b.String())
+			}
+		})
+	}
+}
+
+var parseComponentDelimitedErrorTests = []struct {
+	text   string
+	delims Delimiters
+	err    ComponentParseError
+}{
+	{"ok\n  [[ if ]]", Delimiters{"[[", "]]"}, ComponentParseError{"c.tpl", 2, 3, "missing value for if"}},
+	{"{{ delims \"<%\" \"%>\" }}\nok\n  <% if %>", Delimiters{}, ComponentParseError{"c.tpl", 3, 3, "missing value for if"}},
+	{"{{ delims \"<%\" \"%>\" }}\ntext\n<% param \"a\" %>", Delimiters{}, ComponentParseError{"c.tpl", 3, 4, "param must be declared at the top of the component"}},
+	{"{{ delims \"<%\" \"\" }}\n", Delimiters{}, ComponentParseError{"c.tpl", 1, 0, "delims takes two non-empty strings"}},
+}
+
+func TestParseComponentDelimitedErrors(t *testing.T) {
+	for _, tt := range parseComponentDelimitedErrorTests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, err := ParseComponentDelimited("c.tpl", tt.text, tt.delims)
+			pe, is := err.(*ComponentParseError)
+			if !is {
+				t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+			}
+			if *pe != tt.err {
+				t.Errorf("incorrect error - expected %v, got %v", &tt.err, pe)
+			}
+		})
+	}
+}
+
+func TestCacheComponentResolverDelimiters(t *testing.T) {
+	reads := 0
+	r := NewCacheComponentResolver(func(path string) ([]byte, error) {
+		reads++
+		return []byte("{{ .Vars.v }}[[ .Vars.v ]]"), nil
+	})
+
+	plain, _ := r.Resolve("a")
+	square, _ := r.ResolveDelimited("a", Delimiters{"[[", "]]"})
+	if plain == square {
+		t.Fatalf("components parsed with different delimiters were shared")
+	}
+	if again, _ := r.ResolveDelimited("a", Delimiters{"[[", "]]"}); again != square {
+		t.Errorf("component was not cached by its delimiters")
+	}
+	if again, _ := r.ResolveDelimited("a", Delimiters{}); again != plain {
+		t.Errorf("default delimiters were not cached with Resolve")
+	}
+	if reads != 2 {
+		t.Errorf("incorrect number of reads - expected %d, got %d", 2, reads)
+	}
+
+	r.Invalidate("a")
+	r.Resolve("a")
+	r.ResolveDelimited("a", Delimiters{"[[", "]]"})
+	if reads != 4 {
+		t.Errorf("invalidate did not drop every choice of delimiters - expected %d reads, got %d", 4, reads)
+	}
+}
+
+func TestRenderScopeDelimiters(t *testing.T) {
+	files := map[string]string{
+		"page.tpl":   "[[ .Vars.v ]] {{ x }} [[ include \"part.tpl\" ]] [[ include \"own.tpl\" ]]",
+		"part.tpl":   "<[[ .Vars.v ]]>",
+		"own.tpl":    "{{ delims \"<%\" \"%>\" }}\n(<% .Vars.v %>)",
+		"layout.tpl": "<main>[[ block \"content\" . ]][[ end ]]</main>",
+		"child.tpl":  "[[ extends \"layout.tpl\" ]][[ define \"content\" ]][[ .Vars.v ]][[ end ]]",
+	}
+	r := NewTrackingComponentResolver(NewCacheComponentResolver(func(path string) ([]byte, error) {
+		return []byte(files[path]), nil
+	}))
+
+	for component, expected := range map[string]string{
+		"page.tpl":  "value {{ x }} <value> (value)",
+		"child.tpl": "<main>value</main>",
+	} {
+		b := new(bytes.Buffer)
+		scope := NewRenderScope(b, r, staticResolver{}, "", map[string]string{"v": "value"})
+		scope.Delimiters = Delimiters{"[[", "]]"}
+		if err := scope.Render(component); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if b.String() != expected {
+			t.Errorf("incorrect result - expected %q, got %q", expected, b.String())
+		}
+	}
+	if _, h := r.Hits()["own.tpl"]; !h {
+		t.Errorf("delimited resolve was not tracked")
+	}
+
+	scope := NewRenderScope(new(bytes.Buffer), staticResolver{"a": "a"}, staticResolver{}, "", struct{}{})
+	scope.Delimiters = Delimiters{"[[", "]]"}
+	if err := scope.Render("a"); err != ErrDelimitersUnsupported {
+		t.Errorf("incorrect error - expected %v, got %v", ErrDelimitersUnsupported, err)
+	}
+}
+
+func TestBuilderDelimiters(t *testing.T) {
+	src := &mapConfigSource{
+		delims: Delimiters{"<%", "%>"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "t.tpl", Output: "out/a"},
+			"b": {Template: "t.tpl", Output: "out/b", Delims: Delimiters{"[[", "]]"}},
+			"c": {Inherits: []string{"b"}},
+		},
+	}
+	components := NewCacheComponentResolver(func(path string) ([]byte, error) {
+		return []byte("{{ 1 }}<% 2 %>[[ 3 ]]"), nil
+	})
+
+	for resource, expected := range map[string]string{
+		"a": "{{ 1 }}2[[ 3 ]]",
+		"b": "{{ 1 }}<% 2 %>3",
+		"c": "{{ 1 }}<% 2 %>3",
+	} {
+		b := new(bytes.Buffer)
+		if err := NewBuilder(src, components, nil).Render(b, resource); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if b.String() != expected {
+			t.Errorf("incorrect result for %s - expected %q, got %q", resource, expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// plainValue copies v into plain maps, lists and scalars that every encoder
+// handles the same way. Maps of any kind become map[string]interface{},
+// lists become []interface{}, and integers become int64 as they are when
+// loaded from config.
+func plainValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	case []byte:
+		return string(n)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = plainValue(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			l[i] = plainValue(rv.Index(i).Interface())
+		}
+		return l
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint())
+	}
+	return v
+}
+
+// encodeJSON encodes v as JSON with map keys in sorted order, leaving HTML
+// characters as they are.
+func encodeJSON(v interface{}, indent string) (string, error) {
+	b := new(bytes.Buffer)
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+	e.SetIndent("", indent)
+	if err := e.Encode(plainValue(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// toJSON encodes v as compact JSON.
+func toJSON(v interface{}) (string, error) {
+	return encodeJSON(v, "")
+}
+
+// toPrettyJSON encodes v as JSON indented by two spaces.
+func toPrettyJSON(v interface{}) (string, error) {
+	return encodeJSON(v, "  ")
+}
+
+// toYAML encodes v as YAML, with map keys in sorted order and without the
+// trailing newline.
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(plainValue(v))
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+// toTOML encodes a map as TOML, with keys in sorted order and without the
+// trailing newline.
+func toTOML(v interface{}) (string, error) {
+	m, is := plainValue(v).(map[string]interface{})
+	if !is {
+		return "", fmt.Errorf("only maps can be encoded as TOML, got %T", v)
+	}
+	tree, err := toml.TreeFromMap(m)
+	if err != nil {
+		return "", err
+	}
+	s, err := tree.ToTomlString()
+	return strings.TrimSuffix(s, "\n"), err
+}
+
+// fromJSON decodes JSON into plain values.
+func fromJSON(s string) (interface{}, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return plainValue(v), nil
+}
+
+// fromTOML decodes TOML into plain values.
+func fromTOML(s string) (interface{}, error) {
+	tree, err := toml.Load(s)
+	if err != nil {
+		return nil, err
+	}
+	return plainValue(tree.ToMap()), nil
+}
+
+// fromCSV decodes CSV with a header row into a list of maps, one for each
+// row after the header.
+func fromCSV(s string) (interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(s)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]interface{}, 0)
+	for i := 1; i < len(records); i++ {
+		row := make(map[string]interface{}, len(records[0]))
+		for j, header := range records[0] {
+			row[header] = records[i][j]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// fromYAML decodes YAML into plain values.
+func fromYAML(s string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return plainValue(v), nil
+}
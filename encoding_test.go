@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var encodingVars = VariableMap{
+	"app": VariableMap{
+		"name":     "web",
+		"replicas": int64(3),
+		"ports":    []interface{}{int64(80), int64(443)},
+		"env":      map[string]interface{}{"B": "2", "A": true},
+		"ratio":    0.5,
+	},
+	"list":  []interface{}{"a", VariableMap{"x": int64(1)}},
+	"yaml":  "b: 1\na:\n  - x\n  - 2.5\n1: one",
+	"json":  "{\"b\": 1, \"a\": [\"x\", 2.5, {\"n\": null}]}",
+	"html":  "<a href=\"x\">",
+	"plain": "text",
+}
+
+var encodingTests = []struct {
+	template string
+	result   string
+	err      string
+}{
+	{"{{ toJson .Vars.app }}", `{"env":{"A":true,"B":"2"},"name":"web","ports":[80,443],"ratio":0.5,"replicas":3}`, ""},
+	{"{{ toJson .Vars.list }} {{ toJson .Vars.plain }} {{ toJson .Vars.html }}", `["a",{"x":1}] "text" "<a href=\"x\">"`, ""},
+	{"{{ toPrettyJson .Vars.app.env }}", "{\n  \"A\": true,\n  \"B\": \"2\"\n}", ""},
+	{"{{ toYaml .Vars.app }}", "env:\n  A: true\n  B: \"2\"\nname: web\nports:\n- 80\n- 443\nratio: 0.5\nreplicas: 3", ""},
+	{"env:{{ toYaml .Vars.app.env | nindent 2 }}", "env:\n  A: true\n  B: \"2\"", ""},
+	{"{{ toToml .Vars.app }}", "name = \"web\"\nports = [80,443]\nratio = 0.5\nreplicas = 3\n\n[env]\n  A = true\n  B = \"2\"", ""},
+	{"{{ toToml .Vars.list }}", "", "only maps can be encoded as TOML, got []interface {}"},
+	{"{{ $v := fromYaml .Vars.yaml }}{{ $v.b }} {{ index $v.a 1 }} {{ index $v \"1\" }} {{ toJson $v }}", `1 2.5 one {"1":"one","a":["x",2.5],"b":1}`, ""},
+	{"{{ $v := fromJson .Vars.json }}{{ add $v.b 1 }} {{ toJson $v }}", `2 {"a":["x",2.5,{"n":null}],"b":1}`, ""},
+	{"{{ fromJson \"[1, 2]\" | len }} {{ fromJson \"null\" }}", "2 <no value>", ""},
+	{"{{ fromJson \"{\" }}", "", "unexpected EOF"},
+	{"{{ fromJson \"1 2\" }}", "", "unexpected data after JSON value"},
+	{"{{ fromYaml \"a: [\" }}", "", "did not find expected node content"},
+}
+
+func TestEncodingFuncs(t *testing.T) {
+	for _, tt := range encodingTests {
+		t.Run(tt.template, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			rctx := &testRCtx{b: new(bytes.Buffer), vars: encodingVars}
+			err = c.Render(rctx)
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("incorrect error - expected %s, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rctx.b.String() != tt.result {
+				t.Errorf("incorrect result - expected %q, got %q", tt.result, rctx.b.String())
+			}
+		})
+	}
+}
+
+func TestEncodingStable(t *testing.T) {
+	for _, f := range []func(interface{}) (string, error){toJSON, toPrettyJSON, toYAML, toTOML} {
+		first, err := f(encodingVars["app"])
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		for i := 0; i < 20; i++ {
+			if s, _ := f(encodingVars["app"]); s != first {
+				t.Fatalf("output changed between runs - first %q, then %q", first, s)
+			}
+		}
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	expected := plainValue(encodingVars["app"])
+
+	s, err := toJSON(encodingVars["app"])
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	v, err := fromJSON(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("JSON did not round trip - expected %v, got %v", expected, v)
+	}
+
+	s, err = toYAML(encodingVars["app"])
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	v, err = fromYAML(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("YAML did not round trip - expected %v, got %v", expected, v)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"text/template"
+)
+
+// Escaping is how the output of components is escaped as they render.
+type Escaping string
+
+const (
+	// EscapeText leaves output as it is. It's the default.
+	EscapeText Escaping = "text"
+	// EscapeHTML escapes output with html/template, which escapes each value
+	// for the context it appears in within the page. Values known to be safe
+	// can be marked with safeHTML and friends to be left as they are.
+	EscapeHTML Escaping = "html"
+)
+
+// escaping returns how the output of the component is escaped - as its front
+// matter says, or as the context says otherwise.
+func (c *Component) escaping(ctx RenderContext) Escaping {
+	if c.Meta.Escape != "" {
+		return c.Meta.Escape
+	}
+	return ctx.Escaping()
+}
+
+// execute runs a component's template, bound to funcs, into the writer of
+// ctx. When escaping HTML, copies of the parse trees are run through
+// html/template instead, since escaping rewrites them.
+func execute(ctx RenderContext, escape Escaping, t *template.Template, funcs template.FuncMap, data interface{}) error {
+	if escape != EscapeHTML {
+		return t.Execute(ctx.Writer(), data)
+	}
+
+	h := htmltemplate.New(t.Name()).Funcs(htmltemplate.FuncMap(funcs))
+	for _, d := range t.Templates() {
+		if d.Tree == nil {
+			continue
+		}
+		if _, err := h.AddParseTree(d.Name(), d.Tree.Copy()); err != nil {
+			return err
+		}
+	}
+	// the root is replaced by the one holding its tree when it is added
+	return h.Lookup(t.Name()).Execute(ctx.Writer(), data)
+}
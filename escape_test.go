@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var escapeComponents = staticResolver{
+	"page.html":    "<p>{{ .Vars.user }}</p><a href=\"/u?name={{ .Vars.user }}\" title=\"{{ .Vars.user }}\">x</a>",
+	"trusted.html": "{{ safeHTML .Vars.markup }}|{{ .Vars.markup }}",
+	"include.html": "<div>{{ include \"part.html\" .Vars.user }}</div>",
+	"part.html":    "<b>{{ .Arg0 }}</b>",
+	"capture.html": "<div>{{ capture \"part.html\" .Vars.user }}</div>{{ capture \"part.html\" \"x\" | len }}",
+	"script.html":  "<script>var user = {{ .Vars.user }};</script>",
+	"child.html":   "{{ extends \"layout.html\" }}{{ define \"content\" }}<i>{{ .Vars.user }}</i>{{ end }}",
+	"layout.html":  "<main>{{ block \"content\" . }}{{ end }}</main>",
+	"broken.html":  "<a href=\"{{ .Vars.user }}",
+}
+
+var escapeTests = []struct {
+	component string
+	escape    Escaping
+	expected  string
+}{
+	{"page.html", EscapeText, "<p><script>\"x\"</p><a href=\"/u?name=<script>\"x\"\" title=\"<script>\"x\"\">x</a>"},
+	{"page.html", EscapeHTML, "<p>&lt;script&gt;&#34;x&#34;</p><a href=\"/u?name=%3cscript%3e%22x%22\" title=\"&lt;script&gt;&#34;x&#34;\">x</a>"},
+	{"trusted.html", EscapeText, "<em>hi</em>|<em>hi</em>"},
+	{"trusted.html", EscapeHTML, "<em>hi</em>|&lt;em&gt;hi&lt;/em&gt;"},
+	{"include.html", EscapeHTML, "<div><b>&lt;script&gt;&#34;x&#34;</b></div>"},
+	{"capture.html", EscapeHTML, "<div><b>&lt;script&gt;&#34;x&#34;</b></div>8"},
+	{"script.html", EscapeHTML, "<script>var user = \"\\u003cscript\\u003e\\\"x\\\"\";</script>"},
+	{"child.html", EscapeHTML, "<main><i>&lt;script&gt;&#34;x&#34;</i></main>"},
+}
+
+func TestRenderScopeEscaping(t *testing.T) {
+	vars := map[string]interface{}{
+		"user":   "<script>\"x\"",
+		"markup": "<em>hi</em>",
+	}
+	for _, tt := range escapeTests {
+		t.Run(string(tt.escape)+" "+tt.component, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, escapeComponents, staticResolver{}, "", vars)
+			scope.Escape = tt.escape
+			if err := scope.Render(tt.component); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("incorrect result - expected %s, got %s", tt.expected, b.String())
+			}
+		})
+	}
+}
+
+func TestRenderScopeEscapingErrors(t *testing.T) {
+	scope := NewRenderScope(new(bytes.Buffer), escapeComponents, staticResolver{}, "", map[string]string{"user": "x"})
+	scope.Escape = EscapeHTML
+	err := scope.Render("broken.html")
+	if err == nil || !strings.Contains(err.Error(), "ends in a non-text context") {
+		t.Errorf("expected an escaping error, got %v", err)
+	}
+}
+
+func TestBuilderEscaping(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"user": "<b>"},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "out/a", Escape: EscapeHTML},
+			"b": {Template: "a.tpl", Output: "out/b"},
+			"c": {Template: "c.tpl", Output: "out/c", Escape: EscapeHTML},
+		},
+	}
+	components := staticResolver{
+		"a.tpl": "<p>{{ .Vars.user }}</p>",
+		"c.tpl": "{{ import \"b\" }}{{ import \"a\" }}",
+	}
+
+	for resource, expected := range map[string]string{
+		"a": "<p>&lt;b&gt;</p>",
+		"b": "<p><b></p>",
+		"c": "<p><b></p><p>&lt;b&gt;</p>",
+	} {
+		b := new(bytes.Buffer)
+		if err := NewBuilder(src, components, nil).Render(b, resource); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if b.String() != expected {
+			t.Errorf("incorrect result for %s - expected %s, got %s", resource, expected, b.String())
+		}
+	}
+}
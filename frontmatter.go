@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ComponentMeta is what a component declares about itself in front matter,
+// a block of TOML at the very top of the file that opens with a line of
+// "+++ yate" and closes with a line of "+++", or a block of YAML when the
+// opening line is "+++ yate yaml". The opening line has to name yate, so
+// that files which start with front matter of their own, such as Hugo
+// content being generated, are left as they are.
+//
+//	+++ yate
+//	description = "A card with a title"
+//	args = ["title"]
+//	escape = "html"
+//	delims = ["[[", "]]"]
+//
+//	[variables]
+//	footer = "-"
+//	+++
+//
+// Variables are defaults for the variables the component is rendered with,
+// beneath those of the resource. Args are required named arguments, as if
+// declared with param. Escape and Delims override those of the resource for
+// this component alone.
+type ComponentMeta struct {
+	Description string
+	Variables   VariableMap
+	Args        []string
+	Escape      Escaping
+	Delims      Delimiters
+}
+
+var frontMatterFences = map[string]string{
+	"+++ yate":      "toml",
+	"+++ yate toml": "toml",
+	"+++ yate yaml": "yaml",
+}
+
+const frontMatterFence = "+++"
+
+// frontMatter splits front matter off the top of text, returning the meta it
+// declares, the rest of the text and the number of lines taken off. Lines of
+// errors are counted from the top of the file.
+func frontMatter(text string) (ComponentMeta, string, int, *ComponentParseError) {
+	meta := ComponentMeta{}
+	first := strings.TrimRight(firstLine(text), " \t\r")
+	format, is := frontMatterFences[first]
+	if !is {
+		return meta, text, 0, nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], " \t\r\n") == frontMatterFence {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return meta, text, 0, &ComponentParseError{Line: 1, Col: 1, Msg: "front matter is never closed"}
+	}
+	block := strings.Join(lines[1:end], "")
+	rest := strings.Join(lines[end+1:], "")
+
+	var m map[string]interface{}
+	if format == "toml" {
+		tree, err := toml.LoadBytes([]byte(block))
+		if err != nil {
+			line, col, msg := tomlError(err)
+			// lines are counted from the opening fence
+			return meta, text, 0, &ComponentParseError{Line: line + 1, Col: col, Msg: msg}
+		}
+		m = plainValue(tree.ToMap()).(map[string]interface{})
+	} else {
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(block), &v); err != nil {
+			return meta, text, 0, &ComponentParseError{Line: 1, Msg: err.Error()}
+		}
+		if v != nil {
+			if m, is = plainValue(v).(map[string]interface{}); !is {
+				return meta, text, 0, &ComponentParseError{Line: 1, Msg: "front matter must be a map"}
+			}
+		}
+	}
+
+	if err := meta.fill(m); err != "" {
+		return meta, text, 0, &ComponentParseError{Line: 1, Msg: err}
+	}
+	return meta, rest, end + 1, nil
+}
+
+func firstLine(text string) string {
+	if i := strings.IndexByte(text, '\n'); i != -1 {
+		return text[:i]
+	}
+	return text
+}
+
+// fill sets the fields of meta from decoded front matter, returning what is
+// wrong with it, if anything.
+func (meta *ComponentMeta) fill(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := m[k]
+		switch k {
+		case "description":
+			s, is := v.(string)
+			if !is {
+				return "description must be a string"
+			}
+			meta.Description = s
+		case "variables":
+			vars, is := plainValue(v).(map[string]interface{})
+			if !is {
+				return "variables must be a table"
+			}
+			meta.Variables = VariableMap(vars)
+		case "args":
+			list, is := v.([]interface{})
+			if !is {
+				return "args must be an array of strings"
+			}
+			for _, a := range list {
+				s, is := a.(string)
+				if !is || s == "" {
+					return "args must be an array of strings"
+				}
+				for _, existing := range meta.Args {
+					if existing == s {
+						return fmt.Sprintf("arg %q is declared more than once", s)
+					}
+				}
+				meta.Args = append(meta.Args, s)
+			}
+		case "escape":
+			s, is := v.(string)
+			if !is || (Escaping(s) != EscapeText && Escaping(s) != EscapeHTML) {
+				return fmt.Sprintf("escape must be %q or %q", EscapeText, EscapeHTML)
+			}
+			meta.Escape = Escaping(s)
+		case "delims":
+			d, is := tomlDelimiters(v)
+			if !is {
+				return "delims must be an array of two non-empty strings"
+			}
+			meta.Delims = d
+		default:
+			return fmt.Sprintf("unknown key %q in front matter - expected one of description, variables, args, escape, delims", k)
+		}
+	}
+	return ""
+}
+
+// localVariables returns the variables a component is rendered with - vars,
+// with the defaults from its front matter filled in beneath them.
+func (c *Component) localVariables(vars interface{}) (interface{}, error) {
+	if len(c.Meta.Variables) == 0 {
+		return vars, nil
+	}
+
+	switch v := vars.(type) {
+	case nil:
+		return c.Meta.Variables.Copy(), nil
+	case VariableMap:
+		return v.Copy().MergeFrom(c.Meta.Variables.Copy())
+	case map[string]interface{}:
+		return VariableMap(v).Copy().MergeFrom(c.Meta.Variables.Copy())
+	}
+	return vars, nil
+}
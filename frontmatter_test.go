@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const tomlFrontMatterComponent = `+++ yate
+description = "A card"
+args = ["title"]
+escape = "html"
+delims = ["[[", "]]"]
+
+[variables]
+footer = "-"
+nested = { a = 1 }
++++
+[[ .Args.title ]] {{ x }} [[ .Vars.footer ]]`
+
+const yamlFrontMatterComponent = `+++ yate yaml
+description: A card
+args: [title]
+variables:
+  footer: "-"
+  nested:
+    a: 1
++++
+{{ .Args.title }}`
+
+func TestComponentFrontMatter(t *testing.T) {
+	expected := ComponentMeta{
+		Description: "A card",
+		Variables:   VariableMap{"footer": "-", "nested": map[string]interface{}{"a": int64(1)}},
+		Args:        []string{"title"},
+		Escape:      EscapeHTML,
+		Delims:      Delimiters{"[[", "]]"},
+	}
+
+	c, err := ParseComponent("c.tpl", tomlFrontMatterComponent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(c.Meta, expected) {
+		t.Errorf("incorrect meta - expected %v, got %v", expected, c.Meta)
+	}
+	if c.Delimiters != expected.Delims {
+		t.Errorf("incorrect delimiters - expected %v, got %v", expected.Delims, c.Delimiters)
+	}
+
+	rctx := &testRCtx{b: new(bytes.Buffer)}
+	if err := c.Render(rctx, "title", "<b>"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rctx.b.String() != "&lt;b&gt; {{ x }} -" {
+		t.Errorf("incorrect result - expected %q, got %q", "&lt;b&gt; {{ x }} -", rctx.b.String())
+	}
+
+	c, err = ParseComponent("c.tpl", yamlFrontMatterComponent)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected.Escape, expected.Delims = "", Delimiters{}
+	if !reflect.DeepEqual(c.Meta, expected) {
+		t.Errorf("incorrect meta - expected %v, got %v", expected, c.Meta)
+	}
+}
+
+var componentFrontMatterTemplates = []struct {
+	name     string
+	template string
+	result   string
+}{
+	{"none", "text", "text"},
+	{"empty", "+++ yate\n+++\ntext", "text"},
+	{"empty yaml", "+++ yate yaml\n+++\ntext", "text"},
+	{"yaml document", "---\na: 1\n---\nb: 2", "---\na: 1\n---\nb: 2"},
+	{"fence later", "text\n+++\n+++\n", "text\n+++\n+++\n"},
+	{"hugo toml", "+++\ntitle = \"x\"\n+++\n{{ 1 }}", "+++\ntitle = \"x\"\n+++\n1"},
+	{"hugo yaml", "+++ yaml\n+++\ntext", "+++ yaml\n+++\ntext"},
+}
+
+func TestComponentFrontMatterTemplates(t *testing.T) {
+	for _, tt := range componentFrontMatterTemplates {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rctx := &testRCtx{b: new(bytes.Buffer)}
+			if err := c.Render(rctx); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rctx.b.String() != tt.result {
+				t.Errorf("incorrect result - expected %q, got %q", tt.result, rctx.b.String())
+			}
+		})
+	}
+}
+
+var componentFrontMatterErrorTests = []struct {
+	name     string
+	template string
+	err      ComponentParseError
+}{
+	{"not closed", "+++ yate\na = 1\n", ComponentParseError{"c.tpl", 1, 1, "front matter is never closed"}},
+	{"toml syntax", "+++ yate\ndescription = \"a\"\nargs = [1 2]\n+++\n", ComponentParseError{"c.tpl", 3, 11, "missing comma"}},
+	{"yaml not a map", "+++ yate yaml\n- a\n+++\n", ComponentParseError{"c.tpl", 1, 0, "front matter must be a map"}},
+	{"unknown key", "+++ yate\ndescripton = \"a\"\n+++\n", ComponentParseError{"c.tpl", 1, 0, "unknown key \"descripton\" in front matter - expected one of description, variables, args, escape, delims"}},
+	{"description", "+++ yate\ndescription = 1\n+++\n", ComponentParseError{"c.tpl", 1, 0, "description must be a string"}},
+	{"variables", "+++ yate\nvariables = 1\n+++\n", ComponentParseError{"c.tpl", 1, 0, "variables must be a table"}},
+	{"args", "+++ yate\nargs = [1]\n+++\n", ComponentParseError{"c.tpl", 1, 0, "args must be an array of strings"}},
+	{"args twice", "+++ yate\nargs = [\"a\", \"a\"]\n+++\n", ComponentParseError{"c.tpl", 1, 0, "arg \"a\" is declared more than once"}},
+	{"escape", "+++ yate\nescape = \"xml\"\n+++\n", ComponentParseError{"c.tpl", 1, 0, "escape must be \"text\" or \"html\""}},
+	{"delims", "+++ yate\ndelims = [\"[[\"]\n+++\n", ComponentParseError{"c.tpl", 1, 0, "delims must be an array of two non-empty strings"}},
+	{"param and arg", "+++ yate\nargs = [\"a\"]\n+++\n{{ param \"a\" }}", ComponentParseError{"c.tpl", 4, 4, "param \"a\" is declared more than once"}},
+	{"lines after", "+++ yate\na = 1\n+++\nok\n  {{ if }}", ComponentParseError{"c.tpl", 1, 0, "unknown key \"a\" in front matter - expected one of description, variables, args, escape, delims"}},
+	{"directive after", "+++ yate\n+++\n{{ delims \"[[\" \"]]\" }}", ComponentParseError{"c.tpl", 3, 1, "function \"delims\" not defined"}},
+	{"template line", "+++ yate\n+++\nok\n  {{ if }}", ComponentParseError{"c.tpl", 4, 3, "missing value for if"}},
+}
+
+func TestComponentFrontMatterErrors(t *testing.T) {
+	for _, tt := range componentFrontMatterErrorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseComponent("c.tpl", tt.template)
+			pe, is := err.(*ComponentParseError)
+			if !is {
+				t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+			}
+			if *pe != tt.err {
+				t.Errorf("incorrect error - expected %v, got %v", &tt.err, pe)
+			}
+		})
+	}
+}
+
+func TestComponentFrontMatterVariables(t *testing.T) {
+	c, err := ParseComponent("c.tpl", "+++ yate\n[variables]\na = \"local\"\nb = \"local\"\n+++\n{{ .Vars.a }} {{ .Vars.b }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tt := range []struct {
+		vars     interface{}
+		expected string
+	}{
+		{nil, "local local"},
+		{VariableMap{"a": "resource"}, "resource local"},
+		{map[string]interface{}{"b": "resource"}, "local resource"},
+	} {
+		rctx := &testRCtx{b: new(bytes.Buffer), vars: tt.vars}
+		if err := c.Render(rctx); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if rctx.b.String() != tt.expected {
+			t.Errorf("incorrect result - expected %q, got %q", tt.expected, rctx.b.String())
+		}
+	}
+
+	resource := VariableMap{"a": "resource"}
+	c.Render(&testRCtx{b: new(bytes.Buffer), vars: resource})
+	if !reflect.DeepEqual(resource, VariableMap{"a": "resource"}) {
+		t.Errorf("resource variables were changed by rendering: %v", resource)
+	}
+}
+
+func TestComponentFrontMatterArgs(t *testing.T) {
+	c, err := ParseComponent("c.tpl", "+++ yate\nargs = [\"title\"]\n+++\n{{ param \"body\" \"-\" }}{{ .Args.title }}{{ .Args.body }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []Param{{"title", nil, true}, {"body", "-", false}}
+	if params, _ := c.Params(); !reflect.DeepEqual(params, expected) {
+		t.Errorf("incorrect params - expected %v, got %v", expected, params)
+	}
+
+	err = c.Render(&testRCtx{b: new(bytes.Buffer)}, "body", "x")
+	if _, is := err.(*ComponentArgumentError); !is || err.Error() != "c.tpl: missing required argument \"title\"" {
+		t.Errorf("incorrect error - expected a missing argument, got %T: %v", err, err)
+	}
+}
+
+func TestCacheComponentResolverFrontMatter(t *testing.T) {
+	r := NewCacheComponentResolver(func(path string) ([]byte, error) {
+		return []byte("+++ yate\ndescription = \"d\"\n+++\ntext"), nil
+	})
+	c, err := r.Resolve("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.Meta.Description != "d" {
+		t.Errorf("incorrect description - expected %q, got %q", "d", c.Meta.Description)
+	}
+}
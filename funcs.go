@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// standardFuncs are the template funcs every component gets on top of
+// text/template's builtins. Funcs that take a value to work on take it last,
+// so they can be used at the end of a pipeline:
+//
+//	{{ .Vars.name | default "world" | upper }}
+var standardFuncs = template.FuncMap{
+	// strings
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      funcTitle,
+	"trim":       strings.TrimSpace,
+	"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       funcJoin,
+	"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+	"quote":      strconv.Quote,
+	"indent":     func(n int, s string) string { return indentLines(s, strings.Repeat(" ", n), true) },
+	"nindent":    func(n int, s string) string { return "\n" + indentLines(s, strings.Repeat(" ", n), true) },
+
+	// defaults
+	"default":  funcDefault,
+	"empty":    isEmpty,
+	"coalesce": funcCoalesce,
+	"ternary":  funcTernary,
+
+	// lists
+	"list":   func(items ...interface{}) []interface{} { return items },
+	"first":  funcFirst,
+	"last":   funcLast,
+	"rest":   funcRest,
+	"append": funcAppend,
+	"has":    funcHas,
+
+	// maps
+	"dict":   funcDict,
+	"keys":   funcKeys,
+	"hasKey": funcHasKey,
+	"get":    funcGet,
+
+	// math
+	"add": func(a, b interface{}) (interface{}, error) { return arithmetic("add", a, b) },
+	"sub": func(a, b interface{}) (interface{}, error) { return arithmetic("sub", a, b) },
+	"mul": func(a, b interface{}) (interface{}, error) { return arithmetic("mul", a, b) },
+	"div": func(a, b interface{}) (interface{}, error) { return arithmetic("div", a, b) },
+	"mod": func(a, b interface{}) (interface{}, error) { return arithmetic("mod", a, b) },
+	"max": func(a, b interface{}) (interface{}, error) { return arithmetic("max", a, b) },
+	"min": func(a, b interface{}) (interface{}, error) { return arithmetic("min", a, b) },
+
+	// serialization
+	"toJson":       toJSON,
+	"toPrettyJson": toPrettyJSON,
+	"toYaml":       toYAML,
+	"toToml":       toTOML,
+	"fromJson":     fromJSON,
+	"fromYaml":     fromYAML,
+
+	// trusted values, left as they are when escaping HTML
+	"safeHTML":     func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+	"safeHTMLAttr": func(s string) htmltemplate.HTMLAttr { return htmltemplate.HTMLAttr(s) },
+	"safeCSS":      func(s string) htmltemplate.CSS { return htmltemplate.CSS(s) },
+	"safeJS":       func(s string) htmltemplate.JS { return htmltemplate.JS(s) },
+	"safeURL":      func(s string) htmltemplate.URL { return htmltemplate.URL(s) },
+
+	// conversions
+	"toString": func(v interface{}) string { return fmt.Sprint(v) },
+	"toInt":    toInt,
+	"toFloat":  toFloat,
+}
+
+// title capitalizes the first letter of every word in s, where words are
+// separated by anything other than letters, digits, underscores and
+// apostrophes.
+func funcTitle(s string) string {
+	b := make([]byte, 0, len(s))
+	prev := ' '
+	for _, r := range s {
+		if isWordSeparator(prev) {
+			r = unicode.ToTitle(r)
+		}
+		b = append(b, string(r)...)
+		prev = r
+	}
+	return string(b)
+}
+
+func isWordSeparator(r rune) bool {
+	if r == '_' || r == '\'' || r == '’' {
+		return false
+	}
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+}
+
+// isEmpty reports whether v is nil or the zero value of its type, or an empty
+// slice, map or string.
+func isEmpty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// default returns v, or def if v is empty.
+func funcDefault(def, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+	return v
+}
+
+// coalesce returns the first of its arguments that isn't empty, or nil.
+func funcCoalesce(vs ...interface{}) interface{} {
+	for _, v := range vs {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// ternary returns a if cond is true, otherwise b.
+func funcTernary(a, b interface{}, cond bool) interface{} {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// listValue returns the items of a slice or array.
+func listValue(list interface{}) ([]interface{}, error) {
+	rv := reflect.ValueOf(list)
+	if !rv.IsValid() {
+		return []interface{}{}, nil
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// join joins the items of a list with sep, formatting each as by print.
+func funcJoin(sep string, list interface{}) (string, error) {
+	items, err := listValue(list)
+	if err != nil {
+		return "", err
+	}
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = fmt.Sprint(item)
+	}
+	return strings.Join(strs, sep), nil
+}
+
+// first returns the first item of a list, or nil if it is empty.
+func funcFirst(list interface{}) (interface{}, error) {
+	items, err := listValue(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// last returns the last item of a list, or nil if it is empty.
+func funcLast(list interface{}) (interface{}, error) {
+	items, err := listValue(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+// rest returns all but the first item of a list.
+func funcRest(list interface{}) ([]interface{}, error) {
+	items, err := listValue(list)
+	if err != nil || len(items) == 0 {
+		return []interface{}{}, err
+	}
+	return items[1:], nil
+}
+
+// append returns a new list of the items of a list followed by v.
+func funcAppend(list interface{}, v interface{}) ([]interface{}, error) {
+	items, err := listValue(list)
+	if err != nil {
+		return nil, err
+	}
+	return append(items, v), nil
+}
+
+// has reports whether a list contains needle.
+func funcHas(needle interface{}, list interface{}) (bool, error) {
+	items, err := listValue(list)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		if reflect.DeepEqual(item, needle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dict creates a map from alternating keys and values.
+func funcDict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("expected alternating keys and values")
+	}
+	d := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, is := pairs[i].(string)
+		if !is {
+			return nil, fmt.Errorf("keys must be strings, got %T", pairs[i])
+		}
+		d[k] = pairs[i+1]
+	}
+	return d, nil
+}
+
+// mapValue checks that m is a map with string keys.
+func mapValue(m interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return rv, fmt.Errorf("expected a map with string keys, got %T", m)
+	}
+	return rv, nil
+}
+
+// keys returns the keys of a map in sorted order.
+func funcKeys(m interface{}) ([]string, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// hasKey reports whether a map has key.
+func funcHasKey(m interface{}, key string) (bool, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return false, err
+	}
+	return rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid(), nil
+}
+
+// get returns the value of key in a map, or nil if it has none.
+func funcGet(m interface{}, key string) (interface{}, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return nil, err
+	}
+	v := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+// toInt converts a number, bool or numeric string to an int64.
+func toInt(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		s := strings.TrimSpace(rv.String())
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot convert %T %v to a number", v, v)
+}
+
+// toFloat converts a number, bool or numeric string to a float64.
+func toFloat(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %T %v to a number", v, v)
+		}
+		return f, nil
+	}
+	i, err := toInt(v)
+	if err != nil {
+		return 0, fmt.Errorf("cannot convert %T %v to a number", v, v)
+	}
+	return float64(i), nil
+}
+
+func isFloat(v interface{}) bool {
+	k := reflect.ValueOf(v).Kind()
+	if k == reflect.String {
+		_, err := strconv.ParseInt(strings.TrimSpace(v.(string)), 0, 64)
+		return err != nil
+	}
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// arithmetic applies op to two numbers. The result is an int64 when both are
+// integers, and a float64 otherwise.
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	if isFloat(a) || isFloat(b) {
+		x, err := toFloat(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := toFloat(b)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add":
+			return x + y, nil
+		case "sub":
+			return x - y, nil
+		case "mul":
+			return x * y, nil
+		case "div":
+			if y == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return x / y, nil
+		case "mod":
+			if y == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return math.Mod(x, y), nil
+		case "max":
+			return math.Max(x, y), nil
+		default:
+			return math.Min(x, y), nil
+		}
+	}
+
+	x, err := toInt(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "add":
+		return x + y, nil
+	case "sub":
+		return x - y, nil
+	case "mul":
+		return x * y, nil
+	case "div":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return x / y, nil
+	case "mod":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return x % y, nil
+	case "max":
+		if x > y {
+			return x, nil
+		}
+		return y, nil
+	default:
+		if x < y {
+			return x, nil
+		}
+		return y, nil
+	}
+}
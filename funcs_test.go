@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var standardFuncsVars = map[string]interface{}{
+	"name":    "world",
+	"blank":   "",
+	"zero":    0,
+	"nothing": nil,
+	"list":    []string{"a", "b", "c"},
+	"empty":   []interface{}{},
+	"nums":    []interface{}{int64(1), int64(2)},
+	"map":     VariableMap{"b": 2, "a": 1},
+	"int":     int64(7),
+	"float":   2.5,
+}
+
+var standardFuncsTests = []struct {
+	template string
+	result   string
+	err      string
+}{
+	// strings
+	{"{{ upper .Vars.name }}", "WORLD", ""},
+	{"{{ \"WoRlD\" | lower }}", "world", ""},
+	{"{{ title \"hello world\" }}", "Hello World", ""},
+	{"{{ title \"élan über-ǆungla o'neil\" }}", "Élan Über-ǅungla O'neil", ""},
+	{"[{{ trim \"  x \\n\" }}]", "[x]", ""},
+	{"{{ trimAll \"-\" \"--x--\" }}", "x", ""},
+	{"{{ trimPrefix \"v\" \"v1.0\" }} {{ trimPrefix \"x\" \"v1.0\" }}", "1.0 v1.0", ""},
+	{"{{ trimSuffix \".tpl\" \"a.tpl\" }}", "a", ""},
+	{"{{ replace \"a\" \"o\" \"banana\" }}", "bonono", ""},
+	{"{{ contains \"orl\" .Vars.name }} {{ contains \"x\" .Vars.name }}", "true false", ""},
+	{"{{ hasPrefix \"wo\" .Vars.name }} {{ hasSuffix \"wo\" .Vars.name }}", "true false", ""},
+	{"{{ split \",\" \"a,b,,c\" | len }}", "4", ""},
+	{"{{ join \"-\" .Vars.list }} {{ join \",\" .Vars.nums }} [{{ join \",\" .Vars.empty }}]", "a-b-c 1,2 []", ""},
+	{"{{ join \",\" .Vars.name }}", "", "expected a list, got string"},
+	{"{{ split \",\" \"a,b\" | join \"+\" }}", "a+b", ""},
+	{"{{ repeat 3 \"ab\" }}[{{ repeat 0 \"ab\" }}]", "ababab[]", ""},
+	{"{{ quote .Vars.name }} {{ quote \"a\\\"b\" }}", "\"world\" \"a\\\"b\"", ""},
+	{"{{ indent 2 \"a\\n\\nb\" }}", "  a\n\n  b", ""},
+	{"x:{{ nindent 2 \"a\\nb\" }}", "x:\n  a\n  b", ""},
+
+	// defaults
+	{"{{ .Vars.blank | default \"x\" }} {{ .Vars.name | default \"x\" }}", "x world", ""},
+	{"{{ .Vars.zero | default 5 }} {{ .Vars.nothing | default 5 }} {{ .Vars.empty | default 5 }}", "5 5 5", ""},
+	{"{{ .Vars.missing | default \"x\" }}", "x", ""},
+	{"{{ empty .Vars.blank }} {{ empty .Vars.zero }} {{ empty .Vars.map }} {{ empty .Vars.nothing }} {{ empty false }}", "true true false true true", ""},
+	{"{{ coalesce .Vars.blank .Vars.nothing .Vars.name \"x\" }} {{ coalesce .Vars.blank }}", "world <no value>", ""},
+	{"{{ ternary \"yes\" \"no\" true }} {{ ternary \"yes\" \"no\" (eq .Vars.name \"x\") }}", "yes no", ""},
+
+	// lists
+	{"{{ list 1 \"a\" true }} {{ list | len }}", "[1 a true] 0", ""},
+	{"{{ first .Vars.list }} {{ last .Vars.list }} {{ rest .Vars.list }}", "a c [b c]", ""},
+	{"{{ first .Vars.empty }} {{ last .Vars.empty }} {{ rest .Vars.empty }}", "<no value> <no value> []", ""},
+	{"{{ first .Vars.map }}", "", "expected a list, got main.VariableMap"},
+	{"{{ append .Vars.list \"d\" }} {{ .Vars.list }}", "[a b c d] [a b c]", ""},
+	{"{{ has \"b\" .Vars.list }} {{ has \"x\" .Vars.list }} {{ has 1 .Vars.empty }}", "true false false", ""},
+
+	// maps
+	{"{{ $d := dict \"a\" 1 \"b\" \"x\" }}{{ $d.a }} {{ $d.b }} {{ len $d }}", "1 x 2", ""},
+	{"{{ dict | len }}", "0", ""},
+	{"{{ dict \"a\" }}", "", "expected alternating keys and values"},
+	{"{{ dict 1 2 }}", "", "keys must be strings, got int"},
+	{"{{ keys .Vars.map }} {{ keys (dict) }}", "[a b] []", ""},
+	{"{{ keys .Vars.list }}", "", "expected a map with string keys, got []string"},
+	{"{{ hasKey .Vars.map \"a\" }} {{ hasKey .Vars.map \"x\" }}", "true false", ""},
+	{"{{ get .Vars.map \"b\" }} {{ get .Vars.map \"x\" }}", "2 <no value>", ""},
+
+	// math
+	{"{{ add 1 2 }} {{ sub 1 2 }} {{ mul 3 4 }} {{ div 7 2 }} {{ mod 7 2 }}", "3 -1 12 3 1", ""},
+	{"{{ add .Vars.int 1 }} {{ add .Vars.float 1 }} {{ mul .Vars.float 2 }} {{ div 7.0 2 }}", "8 3.5 5 3.5", ""},
+	{"{{ max 1 2 }} {{ min 1 2 }} {{ max .Vars.float 1 }} {{ min -1.5 1 }}", "2 1 2.5 -1.5", ""},
+	{"{{ add \"2\" 3 }} {{ add \"1.5\" 1 }}", "5 2.5", ""},
+	{"{{ div 1 0 }}", "", "division by zero"},
+	{"{{ mod 1.0 0 }}", "", "division by zero"},
+	{"{{ add \"x\" 1 }}", "", "cannot convert string x to a number"},
+	{"{{ add .Vars.list 1 }}", "", "cannot convert []string [a b c] to a number"},
+
+	// conversions
+	{"{{ toString 1 | printf \"%q\" }} {{ toString .Vars.nothing }}", "\"1\" <nil>", ""},
+	{"{{ toInt \"42\" }} {{ toInt 2.9 }} {{ toInt true }} {{ toInt \"0x10\" }} {{ toInt \" 3.5 \" }}", "42 2 1 16 3", ""},
+	{"{{ toFloat \"1.5\" }} {{ toFloat 2 }}", "1.5 2", ""},
+	{"{{ toInt \"x\" }}", "", "cannot convert string x to a number"},
+	{"{{ toFloat \"x\" }}", "", "cannot convert string x to a number"},
+}
+
+func TestStandardFuncs(t *testing.T) {
+	for _, tt := range standardFuncsTests {
+		t.Run(tt.template, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			rctx := &testRCtx{b: new(bytes.Buffer), vars: standardFuncsVars}
+			err = c.Render(rctx)
+			if tt.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+					t.Fatalf("incorrect error - expected %s, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rctx.b.String() != tt.result {
+				t.Errorf("incorrect result - expected %q, got %q", tt.result, rctx.b.String())
+			}
+		})
+	}
+}
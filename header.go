@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// componentHeader holds the directives at the top of a component, before
+// anything other than whitespace and comments:
+//
+//	{{ extends "layout.tpl" }}
+//	{{ param "title" }}
+//	{{ param "body" "default body" }}
+type componentHeader struct {
+	params  []Param
+	extends string
+}
+
+// the template funcs standing in for header directives, which are read from
+// the parse tree rather than run
+var headerDirectives = map[string]interface{}{
+	"param": func(name string, def ...interface{}) string {
+		return ""
+	},
+	"extends": func(layout string) string {
+		return ""
+	},
+}
+
+// directiveCall returns the name and arguments of an action that is a call
+// to a header directive.
+func directiveCall(n parse.Node) (string, []parse.Node, bool) {
+	action, is := n.(*parse.ActionNode)
+	if !is || len(action.Pipe.Cmds) != 1 || len(action.Pipe.Decl) != 0 {
+		return "", nil, false
+	}
+	args := action.Pipe.Cmds[0].Args
+	id, is := args[0].(*parse.IdentifierNode)
+	if !is {
+		return "", nil, false
+	}
+	if _, is := headerDirectives[id.Ident]; !is {
+		return "", nil, false
+	}
+	return id.Ident, args[1:], true
+}
+
+func constantValue(n parse.Node) (interface{}, bool) {
+	switch v := n.(type) {
+	case *parse.StringNode:
+		return v.Text, true
+	case *parse.BoolNode:
+		return v.True, true
+	case *parse.NumberNode:
+		if v.IsInt {
+			return int(v.Int64), true
+		} else if v.IsFloat {
+			return v.Float64, true
+		}
+	case *parse.NilNode:
+		return nil, true
+	}
+	return nil, false
+}
+
+func (c *Component) headerError(n parse.Node, msg string) error {
+	e := &ComponentParseError{
+		Filepath: c.Filepath,
+		Msg:      msg,
+	}
+	// the location looks like "<name>:<line>:<col>", with col counted from 0
+	location, _ := c.Tree.ErrorContext(n)
+	parts := strings.Split(location, ":")
+	if len(parts) >= 3 {
+		e.Line, _ = strconv.Atoi(parts[len(parts)-2])
+		e.Line += c.lineOffset
+		e.Col, _ = strconv.Atoi(parts[len(parts)-1])
+		e.Col++
+	}
+	return e
+}
+
+// parsedHeader returns the header read when the component was parsed, or
+// reads it now for components that were parsed some other way.
+func (c *Component) parsedHeader() (componentHeader, error) {
+	if c.header != nil {
+		return *c.header, nil
+	}
+	return c.readHeader()
+}
+
+func (c *Component) readHeader() (componentHeader, error) {
+	h := componentHeader{params: make([]Param, 0)}
+	for _, a := range c.Meta.Args {
+		h.params = append(h.params, Param{Name: a, Required: true})
+	}
+	if c.Tree == nil || c.Tree.Root == nil {
+		return h, nil
+	}
+
+	nodes := c.Tree.Root.Nodes
+	inHeader := true
+	for i, n := range nodes {
+		if inHeader {
+			if t, is := n.(*parse.TextNode); is && len(strings.TrimSpace(string(t.Text))) == 0 {
+				continue
+			} else if _, is := n.(*parse.CommentNode); is {
+				continue
+			}
+		}
+
+		directive, args, is := directiveCall(n)
+		if !is {
+			inHeader = false
+			if err := c.misplacedDirectives(nodes[i:i+1], true); err != nil {
+				return h, err
+			}
+			continue
+		} else if !inHeader {
+			return h, c.headerError(n, directive+" must be declared at the top of the component")
+		}
+
+		var err error
+		switch directive {
+		case "param":
+			err = c.headerParam(&h, n, args)
+		case "extends":
+			err = c.headerExtends(&h, n, args)
+		}
+		if err != nil {
+			return h, err
+		}
+	}
+
+	// nor are they ever part of the templates a component defines
+	defined := c.Templates()
+	sort.Slice(defined, func(i, j int) bool {
+		return defined[i].Name() < defined[j].Name()
+	})
+	for _, d := range defined {
+		if d.Tree == nil || d.Tree == c.Tree || d.Tree.Root == nil {
+			continue
+		}
+		if err := c.misplacedDirectives(d.Tree.Root.Nodes, false); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+func (c *Component) headerParam(h *componentHeader, n parse.Node, args []parse.Node) error {
+	if len(args) == 0 || len(args) > 2 {
+		return c.headerError(n, "param takes a name and an optional default")
+	}
+	name, is := args[0].(*parse.StringNode)
+	if !is {
+		return c.headerError(n, "param name must be a string")
+	}
+
+	p := Param{Name: name.Text, Required: len(args) == 1}
+	if !p.Required {
+		if p.Default, is = constantValue(args[1]); !is {
+			return c.headerError(n, "param default must be a constant")
+		}
+	}
+
+	for _, existing := range h.params {
+		if existing.Name == p.Name {
+			return c.headerError(n, "param \""+p.Name+"\" is declared more than once")
+		}
+	}
+	h.params = append(h.params, p)
+	return nil
+}
+
+func (c *Component) headerExtends(h *componentHeader, n parse.Node, args []parse.Node) error {
+	if h.extends != "" {
+		return c.headerError(n, "a component can only extend one layout")
+	}
+	if len(args) != 1 {
+		return c.headerError(n, "extends takes the path of a layout")
+	}
+	layout, is := args[0].(*parse.StringNode)
+	if !is || layout.Text == "" {
+		return c.headerError(n, "extends takes the path of a layout")
+	}
+	h.extends = layout.Text
+	return nil
+}
+
+// finds any directives inside the nodes, which can only be nested in other
+// actions and so are never at the top of the component
+func (c *Component) misplacedDirectives(nodes []parse.Node, top bool) error {
+	for _, n := range nodes {
+		if directive, _, is := directiveCall(n); is && !top {
+			return c.headerError(n, directive+" must be declared at the top of the component")
+		}
+
+		var lists []*parse.ListNode
+		switch v := n.(type) {
+		case *parse.ListNode:
+			lists = append(lists, v)
+		case *parse.IfNode:
+			lists = append(lists, v.List, v.ElseList)
+		case *parse.RangeNode:
+			lists = append(lists, v.List, v.ElseList)
+		case *parse.WithNode:
+			lists = append(lists, v.List, v.ElseList)
+		}
+		for _, l := range lists {
+			if l == nil {
+				continue
+			}
+			if err := c.misplacedDirectives(l.Nodes, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
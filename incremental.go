@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// IncrementalBuilder keeps resources built as the files they depend on change.
+//
+// Each resource gets its own watcher over the component files it resolved
+// while last being rendered, so editing a partial only rebuilds the resources
+// that include it. The config file has a watcher of its own - when it changes
+// only resources whose effective config could have changed are rebuilt, which
+// is any resource whose own config, the config of a resource it inherits from
+// or imports, or the globals, global delimiters or merge options differ from
+// when it was last built. A resource that was missing then and has since been
+// added counts as differing.
+type IncrementalBuilder struct {
+	// Load reads the current resource configs.
+	Load func() (ResourceConfigSource, error)
+	// Components creates the resolver used for a round of rebuilds.
+	Components func() ComponentResolver
+	Open       OutputOpener
+	Watcher    ActionedFileWatcher
+	// Path maps a component or config path to the path that is watched.
+	Path func(string) string
+	// Report is told about every build and every failure to load config.
+	Report func(BuildResult)
+	// Overrides, if set, are merged over the variables of every resource.
+	Overrides *Overrides
+
+	mx         sync.Mutex
+	configFile string
+	source     ResourceConfigSource
+	resources  map[string]*builtResource
+}
+
+type builtResource struct {
+	watcher FileWatcher
+	globals VariableMap
+	delims  Delimiters
+	merge   MergeOptions
+	// nil for resources that were depended on but didn't exist
+	configs map[string]*ResourceConfig
+}
+
+// Start builds every resource and starts watching their dependencies, along
+// with the config file itself.
+func (i *IncrementalBuilder) Start(configFile string) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	src, err := i.Load()
+	if err != nil {
+		return err
+	}
+	i.configFile = configFile
+	i.source = src
+	i.resources = make(map[string]*builtResource)
+
+	components := i.Components()
+	for _, r := range src.Resources() {
+		i.build(r, components)
+	}
+
+	return i.Watcher.Create(i.reload).Add(i.Path(configFile))
+}
+
+func (i *IncrementalBuilder) rebuild(resource string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	if _, h := i.resources[resource]; !h {
+		// removed from the config since the change was seen
+		return
+	}
+	i.build(resource, i.Components())
+}
+
+func (i *IncrementalBuilder) reload() {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	src, err := i.Load()
+	if err != nil {
+		i.Report(BuildResult{
+			Resource: i.configFile,
+			Err:      err,
+		})
+		return
+	}
+	i.source = src
+
+	known := make(map[string]struct{})
+	for _, r := range src.Resources() {
+		known[r] = struct{}{}
+	}
+
+	for r, built := range i.resources {
+		if _, h := known[r]; !h {
+			built.watcher.Close()
+			delete(i.resources, r)
+		}
+	}
+
+	components := i.Components()
+	for _, r := range src.Resources() {
+		if i.stale(r, known) {
+			i.build(r, components)
+		}
+	}
+}
+
+func (i *IncrementalBuilder) stale(resource string, known map[string]struct{}) bool {
+	built, h := i.resources[resource]
+	if !h || !reflect.DeepEqual(built.globals, i.source.GlobalVariables()) {
+		return true
+	} else if built.delims != i.source.GlobalDelimiters() {
+		return true
+	} else if !reflect.DeepEqual(built.merge, i.source.GlobalMerge()) {
+		return true
+	}
+
+	for r, cfg := range built.configs {
+		if _, h := known[r]; h != (cfg != nil) {
+			// added or removed
+			return true
+		} else if !h {
+			continue
+		}
+		current := i.source.GetConfig(r)
+		if !current.Is(cfg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *IncrementalBuilder) build(resource string, components ComponentResolver) {
+	src := NewTrackingConfigSource(i.source)
+	comps := NewTrackingComponentResolver(components)
+	b := NewBuilder(src, comps, i.Open)
+	b.Overrides = i.Overrides
+
+	if built, h := i.resources[resource]; h {
+		built.watcher.Close()
+		delete(i.resources, resource)
+	}
+
+	cfg, err := b.Config(resource)
+	if err == nil && cfg.Output == "" {
+		// only exists to be inherited from or imported - nothing to keep built
+		return
+	}
+	if err == nil {
+		err = b.Build(resource)
+	}
+	i.Report(BuildResult{
+		Resource: resource,
+		Err:      err,
+	})
+
+	built := &builtResource{
+		watcher: i.Watcher.Create(func() {
+			i.rebuild(resource)
+		}),
+		globals: i.source.GlobalVariables(),
+		delims:  i.source.GlobalDelimiters(),
+		merge:   i.source.GlobalMerge(),
+		configs: make(map[string]*ResourceConfig),
+	}
+	known := make(map[string]struct{})
+	for _, r := range i.source.Resources() {
+		known[r] = struct{}{}
+	}
+	for r := range src.Hits() {
+		built.configs[r] = nil
+		if _, h := known[r]; h {
+			cfg := i.source.GetConfig(r)
+			built.configs[r] = &cfg
+		}
+	}
+	for path := range comps.Hits() {
+		if err := built.watcher.Add(i.Path(path)); err != nil {
+			i.Report(BuildResult{
+				Resource: resource,
+				Err:      fmt.Errorf("cannot watch %s: %s", i.Path(path), err),
+			})
+		}
+	}
+	i.resources[resource] = built
+}
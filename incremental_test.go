@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type mockActionWatcher struct {
+	action Action
+	paths  []string
+	closed bool
+}
+
+func (m *mockActionWatcher) Add(path string) error {
+	m.paths = append(m.paths, path)
+	return nil
+}
+
+func (m *mockActionWatcher) Close() error {
+	m.closed = true
+	return nil
+}
+
+type mockActionedWatcher struct {
+	watchers []*mockActionWatcher
+}
+
+func (m *mockActionedWatcher) Create(action Action) FileWatcher {
+	w := &mockActionWatcher{action: action}
+	m.watchers = append(m.watchers, w)
+	return w
+}
+
+func (m *mockActionedWatcher) Close() error {
+	return nil
+}
+
+// runs the action of every open watcher watching path, as if it changed
+func (m *mockActionedWatcher) change(path string) {
+	for _, w := range append([]*mockActionWatcher{}, m.watchers...) {
+		if !w.closed && in(path, w.paths) {
+			w.action()
+		}
+	}
+}
+
+func (m *mockActionedWatcher) open() int {
+	n := 0
+	for _, w := range m.watchers {
+		if !w.closed {
+			n++
+		}
+	}
+	return n
+}
+
+type incrementalTest struct {
+	t          *testing.T
+	src        *mapConfigSource
+	loadErr    error
+	components staticResolver
+	watcher    *mockActionedWatcher
+	built      []string
+	inc        *IncrementalBuilder
+}
+
+func newIncrementalTest(t *testing.T) *incrementalTest {
+	it := &incrementalTest{
+		t: t,
+		src: &mapConfigSource{
+			globals: VariableMap{"g": "global"},
+			resources: map[string]ResourceConfig{
+				"a": {Template: "a.tpl", Output: "a"},
+				"b": {Template: "b.tpl", Output: "b"},
+				"c": {Output: "c", Inherits: []string{"d"}},
+				"d": {Template: "d.tpl", Variables: VariableMap{"v": "d"}},
+			},
+		},
+		watcher: &mockActionedWatcher{},
+	}
+
+	it.components = staticResolver{
+		"a.tpl":    "{{ include \"part.tpl\" }}",
+		"b.tpl":    "{{ .Vars.g }}",
+		"d.tpl":    "{{ .Vars.v }}",
+		"part.tpl": "part",
+	}
+	it.inc = &IncrementalBuilder{
+		Load: func() (ResourceConfigSource, error) {
+			return it.src, it.loadErr
+		},
+		Components: func() ComponentResolver {
+			return it.components
+		},
+		Open:    make(memoryOutputs).Open,
+		Watcher: it.watcher,
+		Path: func(path string) string {
+			return "project/" + path
+		},
+		Report: func(r BuildResult) {
+			if r.Err != nil {
+				t.Errorf("unexpected error building %s: %s", r.Resource, r.Err)
+			}
+			it.built = append(it.built, r.Resource)
+		},
+	}
+	return it
+}
+
+func (it *incrementalTest) expectBuilt(when string, resources ...string) {
+	sort.Strings(it.built)
+	if len(it.built) == 0 && len(resources) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(it.built, resources) {
+		it.t.Errorf("%s: incorrect resources built - expected %v, got %v", when, resources, it.built)
+	}
+	it.built = nil
+}
+
+// replaces the config source, as if the config file was edited
+func (it *incrementalTest) edit(f func(src *mapConfigSource)) {
+	src := &mapConfigSource{
+		globals:   it.src.globals.Copy(),
+		delims:    it.src.delims,
+		merge:     it.src.merge,
+		resources: make(map[string]ResourceConfig),
+	}
+	for k, v := range it.src.resources {
+		if v.Variables != nil {
+			v.Variables = v.Variables.Copy()
+		}
+		src.resources[k] = v
+	}
+	f(src)
+	it.src = src
+	it.watcher.change("project/resources.toml")
+}
+
+func TestIncrementalBuilder(t *testing.T) {
+	it := newIncrementalTest(t)
+	if err := it.inc.Start("resources.toml"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	it.expectBuilt("start", "a", "b", "c")
+
+	it.watcher.change("project/part.tpl")
+	it.expectBuilt("partial changed", "a")
+
+	it.watcher.change("project/d.tpl")
+	it.expectBuilt("inherited template changed", "c")
+
+	it.edit(func(src *mapConfigSource) {
+		src.resources["d"] = ResourceConfig{Template: "d.tpl", Variables: VariableMap{"v": "changed"}}
+	})
+	it.expectBuilt("parent config changed", "c")
+
+	it.edit(func(src *mapConfigSource) {})
+	it.expectBuilt("config unchanged")
+
+	it.edit(func(src *mapConfigSource) {
+		src.globals["g"] = "changed"
+	})
+	it.expectBuilt("globals changed", "a", "b", "c")
+
+	it.edit(func(src *mapConfigSource) {
+		delete(src.resources, "b")
+	})
+	it.expectBuilt("resource removed")
+
+	// one for each of a and c, plus the config file
+	if it.watcher.open() != 3 {
+		t.Errorf("incorrect number of open watchers - expected %d, got %d", 3, it.watcher.open())
+	}
+
+	it.watcher.change("project/b.tpl")
+	it.expectBuilt("removed resource template changed")
+}
+
+func TestIncrementalBuilderOverrides(t *testing.T) {
+	it := newIncrementalTest(t)
+	outputs := make(memoryOutputs)
+	it.inc.Open = outputs.Open
+	it.inc.Overrides = NewOverrides()
+	if err := it.inc.Overrides.SetFlags([]string{"g=flag"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := it.inc.Start("resources.toml"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	it.expectBuilt("start", "a", "b", "c")
+	if outputs["b"].String() != "flag" {
+		t.Errorf("incorrect output - expected %q, got %q", "flag", outputs["b"].String())
+	}
+
+	it.watcher.change("project/b.tpl")
+	it.expectBuilt("template changed", "b")
+	if outputs["b"].String() != "flag" {
+		t.Errorf("incorrect output after rebuild - expected %q, got %q", "flag", outputs["b"].String())
+	}
+}
+
+func TestIncrementalBuilderLoadFailure(t *testing.T) {
+	it := newIncrementalTest(t)
+	if err := it.inc.Start("resources.toml"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	it.built = nil
+
+	loadErr := errors.New("bad config")
+	var reported error
+	it.inc.Report = func(r BuildResult) {
+		reported = r.Err
+	}
+	it.loadErr = loadErr
+	it.watcher.change("project/resources.toml")
+
+	if reported != loadErr {
+		t.Errorf("incorrect error reported - expected %v, got %v", loadErr, reported)
+	}
+}
+
+func TestIncrementalBuilderBrokenPartial(t *testing.T) {
+	it := newIncrementalTest(t)
+
+	// watched through the manager, with a raw watcher that can't watch files
+	// missing from the components
+	raw := mockFW()
+	raw.missing = func(path string) bool {
+		_, h := it.components[strings.TrimPrefix(path, "project/")]
+		return !h && path != "project" && path != "project/resources.toml"
+	}
+	delay, quiet := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, raw)
+	defer mgr.Close()
+	it.inc.Watcher = mgr
+
+	results := make(chan BuildResult, 16)
+	it.inc.Report = func(r BuildResult) {
+		results <- r
+	}
+	expectResult := func(when string, fails bool) {
+		select {
+		case r := <-results:
+			if r.Resource != "a" || (r.Err != nil) != fails {
+				t.Errorf("%s: incorrect result - got %s with error %v", when, r.Resource, r.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: a was not rebuilt", when)
+		}
+	}
+
+	if err := it.inc.Start("resources.toml"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for range []string{"a", "b", "c"} {
+		<-results
+	}
+
+	delete(it.components, "part.tpl")
+	raw.events <- fsnotify.Event{Name: "project/part.tpl", Op: fsnotify.Remove}
+	fire(t, quiet)
+	expectResult("partial removed", true)
+
+	// only its directory can be watched now, which sees it come back
+	it.components["part.tpl"] = "part"
+	raw.events <- fsnotify.Event{Name: "project/part.tpl", Op: fsnotify.Create}
+	fire(t, quiet)
+	expectResult("partial restored", false)
+}
+
+func TestIncrementalBuilderMissingParent(t *testing.T) {
+	it := newIncrementalTest(t)
+	delete(it.src.resources, "d")
+
+	var reported error
+	it.inc.Report = func(r BuildResult) {
+		reported = r.Err
+		it.built = append(it.built, r.Resource)
+	}
+	if err := it.inc.Start("resources.toml"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	it.expectBuilt("start", "a", "b", "c")
+	if _, is := reported.(*UnknownResourceError); !is {
+		t.Errorf("expected an unknown resource error building c, got %v", reported)
+	}
+
+	it.edit(func(src *mapConfigSource) {})
+	it.expectBuilt("config unchanged")
+
+	reported = nil
+	it.edit(func(src *mapConfigSource) {
+		src.resources["d"] = ResourceConfig{Template: "d.tpl", Variables: VariableMap{"v": "d"}}
+	})
+	it.expectBuilt("parent added", "c")
+	if reported != nil {
+		t.Errorf("unexpected error: %s", reported)
+	}
+}
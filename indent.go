@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// columnWriter keeps track of the column the output written through it is at.
+type columnWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (c *columnWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	written := p[:n]
+	if i := bytes.LastIndexByte(written, '\n'); i != -1 {
+		c.col = utf8.RuneCount(written[i+1:])
+	} else {
+		c.col += utf8.RuneCount(written)
+	}
+	return n, err
+}
+
+// indentLines prefixes the lines of text with indent, skipping empty lines and
+// the first line unless first is set.
+func indentLines(text, indent string, first bool) string {
+	if indent == "" {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if l == "" || (i == 0 && !first) {
+			continue
+		}
+		lines[i] = indent + l
+	}
+	return strings.Join(lines, "\n")
+}
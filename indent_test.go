@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+var indentLinesTests = []struct {
+	text     string
+	indent   string
+	first    bool
+	expected string
+}{
+	{"a\nb", "  ", true, "  a\n  b"},
+	{"a\nb", "  ", false, "a\n  b"},
+	{"a\n\nb", "  ", true, "  a\n\n  b"},
+	{"", "  ", true, ""},
+	{"a\nb", "", true, "a\nb"},
+}
+
+func TestIndentLines(t *testing.T) {
+	for _, tt := range indentLinesTests {
+		if r := indentLines(tt.text, tt.indent, tt.first); r != tt.expected {
+			t.Errorf("indentLines(%q, %q, %v) - expected %q, got %q", tt.text, tt.indent, tt.first, tt.expected, r)
+		}
+	}
+}
+
+func TestColumnWriter(t *testing.T) {
+	w := &columnWriter{w: new(bytes.Buffer)}
+	for _, tt := range []struct {
+		write string
+		col   int
+	}{
+		{"ab", 2},
+		{"cd", 4},
+		{"\n", 0},
+		{"x\nyé", 2},
+		{"", 2},
+	} {
+		w.Write([]byte(tt.write))
+		if w.col != tt.col {
+			t.Errorf("incorrect column after %q - expected %d, got %d", tt.write, tt.col, w.col)
+		}
+	}
+}
+
+var indentIncludeComponents = staticResolver{
+	"container.yaml": "name: {{ .Arg0 }}\nports:\n{{ include \"port.yaml\" }}\n\n",
+	"port.yaml":      "- 80\n- 443\n",
+	"env.yaml":       "env:\n  {{ nestInclude \"vars.yaml\" }}\n",
+	"vars.yaml":      "A: 1\nB: 2",
+	"nested.yaml":    "spec:\n  containers:\n    - {{ nestInclude \"container.yaml\" \"web\" }}\n    - {{ nestInclude \"env.yaml\" }}\n",
+	"indented.yaml":  "spec:\n{{ indentInclude 2 \"container.yaml\" \"web\" }}\ndone",
+	"negative.yaml":  "{{ indentInclude -1 \"port.yaml\" }}",
+	"missing.yaml":   "{{ nestInclude \"nothing.yaml\" }}",
+}
+
+var indentIncludeTests = []struct {
+	component string
+	expected  string
+	err       bool
+}{
+	{"indented.yaml", "spec:\n  name: web\n  ports:\n  - 80\n  - 443\ndone", false},
+	{"nested.yaml", "spec:\n  containers:\n    - name: web\n      ports:\n      - 80\n      - 443\n    - env:\n        A: 1\n        B: 2\n", false},
+	{"negative.yaml", "", true},
+	{"missing.yaml", "", true},
+}
+
+func TestRenderScopeIndentInclude(t *testing.T) {
+	for _, tt := range indentIncludeTests {
+		t.Run(tt.component, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, indentIncludeComponents, staticResolver{}, "", struct{}{})
+			err := scope.Render(tt.component)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("incorrect result - expected %q, got %q", tt.expected, b.String())
+			}
+		})
+	}
+}
+
+func TestIndentIncludeImports(t *testing.T) {
+	src := &mapConfigSource{
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "out/a"},
+			"b": {Template: "b.tpl"},
+		},
+	}
+	components := staticResolver{
+		"a.tpl":    "root:\n  {{ nestInclude \"part.tpl\" }}\nend",
+		"part.tpl": "x: 1\n{{ import \"b\" }}",
+		"b.tpl":    "y: 2\nz: 3\n",
+	}
+
+	b := new(bytes.Buffer)
+	if err := NewBuilder(src, components, nil).Render(b, "a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "root:\n  x: 1\n  y: 2\n  z: 3\nend"
+	if b.String() != expected {
+		t.Errorf("incorrect result - expected %q, got %q", expected, b.String())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+)
+
+type CyclicalInheritanceError struct {
+	Stack []string
+}
+
+func (c *CyclicalInheritanceError) Error() string {
+	return "Cycle detected in inheritance path: " + strings.Join(c.Stack, " -> ")
+}
+
+type UnknownResourceError struct {
+	Resource string
+	Stack    []string
+}
+
+func (u *UnknownResourceError) Error() string {
+	if len(u.Stack) == 0 {
+		return "unknown resource \"" + u.Resource + "\""
+	}
+	return "unknown resource \"" + u.Resource + "\" inherited by " + strings.Join(u.Stack, " -> ")
+}
+
+// InheritanceResolver flattens the Inherits chains of resources from a source
+// into effective configs.
+//
+// Parents are applied in declared order, each one already resolved against
+// its own parents. Variables are merged by the merge options of the source,
+// with the resource as the child of each parent in turn - by default its own
+// variables win, followed by those of its first parent, then its second, and
+// so on. Template, Output, Escape and Delims are taken from the first parent
+// that sets them when the resource does not, with Delims falling back to the
+// global delimiters of the source.
+type InheritanceResolver struct {
+	Source ResourceConfigSource
+}
+
+func NewInheritanceResolver(source ResourceConfigSource) *InheritanceResolver {
+	return &InheritanceResolver{source}
+}
+
+func (r *InheritanceResolver) Resolve(resource string) (ResourceConfig, error) {
+	eff, _, err := r.ResolveProvenance(resource)
+	return eff, err
+}
+
+// ResolveProvenance resolves a resource like Resolve, also returning where
+// each leaf of its variables was set along the Inherits chain.
+func (r *InheritanceResolver) ResolveProvenance(resource string) (ResourceConfig, Provenance, error) {
+	known := make(map[string]struct{})
+	for _, k := range r.Source.Resources() {
+		known[k] = struct{}{}
+	}
+
+	eff, prov, err := r.resolve(resource, known, []string{})
+	eff.Delims = eff.Delims.Or(r.Source.GlobalDelimiters())
+	return eff, prov, err
+}
+
+func (r *InheritanceResolver) resolve(resource string, known map[string]struct{}, stack []string) (ResourceConfig, Provenance, error) {
+	for _, v := range stack {
+		if v == resource {
+			return ResourceConfig{}, nil, &CyclicalInheritanceError{
+				Stack: append(stack[:len(stack):len(stack)], resource),
+			}
+		}
+	}
+
+	// read before checking it exists, so that tracking sources see the
+	// dependency on resources that are yet to be added
+	cfg := r.Source.GetConfig(resource)
+	if _, h := known[resource]; !h {
+		return ResourceConfig{}, nil, &UnknownResourceError{
+			Resource: resource,
+			Stack:    stack,
+		}
+	}
+
+	// force a copy so sibling parents never share a backing array
+	stack = append(stack[:len(stack):len(stack)], resource)
+
+	eff := ResourceConfig{
+		Template:  cfg.Template,
+		Output:    cfg.Output,
+		Escape:    cfg.Escape,
+		Delims:    cfg.Delims,
+		Inherits:  append([]string{}, cfg.Inherits...),
+		Variables: cfg.Variables.Copy(),
+	}
+	prov := resourceProvenance(r.Source, resource)
+
+	conflicts := MergeConflictsError{}
+	for _, p := range cfg.Inherits {
+		parent, parentProv, err := r.resolve(p, known, stack)
+		if err != nil {
+			return ResourceConfig{}, nil, err
+		}
+
+		if eff.Template == "" {
+			eff.Template = parent.Template
+		}
+		if eff.Output == "" {
+			eff.Output = parent.Output
+		}
+		if eff.Escape == "" {
+			eff.Escape = parent.Escape
+		}
+		if eff.Delims == (Delimiters{}) {
+			eff.Delims = parent.Delims
+		}
+		if _, err := eff.Variables.MergeTracked(prov, parent.Variables, parentProv, r.Source.GlobalMerge()); err != nil {
+			conflicts = append(conflicts, err.(MergeConflictsError)...)
+		}
+	}
+
+	// every conflict with every parent is reported at once
+	if len(conflicts) > 0 {
+		return ResourceConfig{}, nil, conflicts
+	}
+	return eff, prov, nil
+}
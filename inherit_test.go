@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type inheritanceSource map[string]ResourceConfig
+
+func (s inheritanceSource) GlobalVariables() VariableMap {
+	return VariableMap{}
+}
+
+func (s inheritanceSource) GlobalDelimiters() Delimiters {
+	return Delimiters{}
+}
+
+func (s inheritanceSource) GlobalMerge() MergeOptions {
+	return MergeOptions{}
+}
+
+func (s inheritanceSource) GetConfig(resource string) ResourceConfig {
+	return s[resource]
+}
+
+func (s inheritanceSource) Resources() []string {
+	resources := make([]string, 0, len(s))
+	for k := range s {
+		resources = append(resources, k)
+	}
+	return resources
+}
+
+var inheritanceTestSource = inheritanceSource{
+	"root": {
+		Template:  "root.tpl",
+		Output:    "root.out",
+		Variables: VariableMap{"a": "root", "b": "root", "n": map[string]interface{}{"x": "root", "y": "root"}},
+	},
+	"left": {
+		Inherits:  []string{"root"},
+		Variables: VariableMap{"b": "left", "l": "left", "n": map[string]interface{}{"x": "left"}},
+	},
+	"right": {
+		Template:  "right.tpl",
+		Escape:    EscapeHTML,
+		Inherits:  []string{"root"},
+		Variables: VariableMap{"b": "right", "l": "right", "r": "right"},
+	},
+	"diamond": {
+		Output:    "diamond.out",
+		Inherits:  []string{"left", "right"},
+		Variables: VariableMap{"d": "diamond"},
+	},
+	"reversed": {
+		Inherits: []string{"right", "left"},
+	},
+	"loop1":   {Inherits: []string{"loop2"}},
+	"loop2":   {Inherits: []string{"loop3"}},
+	"loop3":   {Inherits: []string{"loop1"}},
+	"self":    {Inherits: []string{"self"}},
+	"orphan":  {Inherits: []string{"left", "missing"}},
+	"inloop":  {Inherits: []string{"root", "loop1"}},
+	"nothing": {},
+	"typed":   {Variables: VariableMap{"m": map[string]string{"a": "typed"}}},
+	"ints":    {Variables: VariableMap{"m": map[string]int{"b": 1}}},
+	"bools":   {Variables: VariableMap{"m": map[string]bool{"c": true}}},
+	"clash":   {Inherits: []string{"typed", "ints", "bools"}},
+}
+
+var inheritanceTests = []struct {
+	resource string
+	result   ResourceConfig
+}{
+	{"root", inheritanceTestSource["root"]},
+	{"nothing", ResourceConfig{Inherits: []string{}, Variables: VariableMap{}}},
+	{"left", ResourceConfig{
+		"root.tpl",
+		"root.out",
+		"",
+		Delimiters{},
+		[]string{"root"},
+		VariableMap{"a": "root", "b": "left", "l": "left", "n": map[string]interface{}{"x": "left", "y": "root"}},
+	}},
+	{"diamond", ResourceConfig{
+		"root.tpl",
+		"diamond.out",
+		EscapeHTML,
+		Delimiters{},
+		[]string{"left", "right"},
+		VariableMap{"a": "root", "b": "left", "d": "diamond", "l": "left", "r": "right", "n": map[string]interface{}{"x": "left", "y": "root"}},
+	}},
+	{"reversed", ResourceConfig{
+		"right.tpl",
+		"root.out",
+		EscapeHTML,
+		Delimiters{},
+		[]string{"right", "left"},
+		VariableMap{"a": "root", "b": "right", "l": "right", "r": "right", "n": map[string]interface{}{"x": "root", "y": "root"}},
+	}},
+}
+
+func TestInheritanceResolver(t *testing.T) {
+	for _, tt := range inheritanceTests {
+		t.Run(tt.resource, func(t *testing.T) {
+			r := NewInheritanceResolver(inheritanceTestSource)
+			cfg, err := r.Resolve(tt.resource)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !cfg.Is(&tt.result) {
+				t.Errorf("incorrect effective config - expected %v, got %v", tt.result, cfg)
+			}
+		})
+	}
+}
+
+func TestInheritanceResolverDoesNotModifySource(t *testing.T) {
+	r := NewInheritanceResolver(inheritanceTestSource)
+	_, err := r.Resolve("diamond")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	left := inheritanceTestSource["left"].Variables["n"].(map[string]interface{})
+	if _, h := left["y"]; h {
+		t.Errorf("resolving modified the variables of a parent in the source")
+	}
+}
+
+var inheritanceErrorTests = []struct {
+	resource string
+	err      error
+}{
+	{"self", &CyclicalInheritanceError{[]string{"self", "self"}}},
+	{"loop2", &CyclicalInheritanceError{[]string{"loop2", "loop3", "loop1", "loop2"}}},
+	{"inloop", &CyclicalInheritanceError{[]string{"inloop", "loop1", "loop2", "loop3", "loop1"}}},
+	{"orphan", &UnknownResourceError{"missing", []string{"orphan"}}},
+	{"missing", &UnknownResourceError{"missing", []string{}}},
+	{"clash", MergeConflictsError{
+		{"m.b", reflect.TypeOf(""), reflect.TypeOf(0)},
+		{"m.c", reflect.TypeOf(""), reflect.TypeOf(true)},
+	}},
+}
+
+func TestInheritanceResolverErrors(t *testing.T) {
+	for _, tt := range inheritanceErrorTests {
+		t.Run(tt.resource, func(t *testing.T) {
+			r := NewInheritanceResolver(inheritanceTestSource)
+			_, err := r.Resolve(tt.resource)
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Errorf("incorrect error - expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCyclicalInheritanceErrorMessage(t *testing.T) {
+	err := &CyclicalInheritanceError{[]string{"a", "b", "a"}}
+	if err.Error() != "Cycle detected in inheritance path: a -> b -> a" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
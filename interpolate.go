@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type InterpolationCycleError struct {
+	Stack []string
+}
+
+func (c *InterpolationCycleError) Error() string {
+	return "Cycle detected in variable interpolation: " + strings.Join(c.Stack, " -> ")
+}
+
+// reference matches a ${name} reference at the start of a string, where name
+// is a dotted key path. Anything else after a $ is left as it is, so that
+// ${{ ... }} and the like pass through.
+var reference = regexp.MustCompile(`^\$\{\s*([A-Za-z0-9_-]+(?:\.[A-Za-z0-9_-]+)*)\s*\}`)
+
+// interpolator expands ${name} references in strings against a variable map,
+// where name is the dotted key path of a variable. A string that is nothing
+// but a reference takes the value of the variable as is, keeping its type,
+// while references within longer strings must be to strings, numbers or
+// bools. $${ is a literal ${.
+//
+// Variables are expanded as they are referenced, each one at most once.
+// Strings are expanded within tables and arrays as read from config, but not
+// within other types of maps and slices.
+type interpolator struct {
+	vars     VariableMap
+	resolved map[string]interface{}
+	stack    [][]string
+}
+
+func newInterpolator(vars VariableMap) *interpolator {
+	return &interpolator{
+		vars:     vars,
+		resolved: make(map[string]interface{}),
+	}
+}
+
+// pathID identifies a key path even when its keys contain dots.
+func pathID(keys []string) string {
+	return strings.Join(keys, "\x00")
+}
+
+// walk returns v, found at keys, with the references in its strings expanded.
+func (in *interpolator) walk(keys []string, v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string, map[string]interface{}, []interface{}:
+	default:
+		return v, nil
+	}
+
+	id := pathID(keys)
+	if ev, h := in.resolved[id]; h {
+		return ev, nil
+	}
+	for i, p := range in.stack {
+		if pathID(p) == id {
+			cycle := make([]string, 0, len(in.stack)-i+1)
+			for _, p := range in.stack[i:] {
+				cycle = append(cycle, strings.Join(p, "."))
+			}
+			return nil, &InterpolationCycleError{Stack: append(cycle, strings.Join(keys, "."))}
+		}
+	}
+
+	in.stack = append(in.stack, keys)
+	defer func() { in.stack = in.stack[:len(in.stack)-1] }()
+
+	var ev interface{}
+	switch v := v.(type) {
+	case string:
+		s, err := in.expand(fmt.Sprintf("variable %q", strings.Join(keys, ".")), v)
+		if err != nil {
+			return nil, err
+		}
+		ev = s
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if l[i], err = in.walk(append(keys[:len(keys):len(keys)], strconv.Itoa(i)), item); err != nil {
+				return nil, err
+			}
+		}
+		ev = l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			var err error
+			if m[k], err = in.walk(append(keys[:len(keys):len(keys)], k), child); err != nil {
+				return nil, err
+			}
+		}
+		ev = m
+	}
+	in.resolved[id] = ev
+	return ev, nil
+}
+
+// find returns the keys and value of the variable at a dotted key path,
+// matching keys that themselves contain dots where there are any.
+func find(v interface{}, path []string, keys []string) ([]string, interface{}, bool) {
+	if len(path) == 0 {
+		return keys, v, true
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for n := len(path); n > 0; n-- {
+			k := strings.Join(path[:n], ".")
+			if child, h := v[k]; h {
+				if fk, fv, found := find(child, path[n:], append(keys[:len(keys):len(keys)], k)); found {
+					return fk, fv, true
+				}
+			}
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(v) {
+			return find(v[i], path[1:], append(keys[:len(keys):len(keys)], path[0]))
+		}
+	}
+	return nil, nil, false
+}
+
+// lookup returns the expanded value of the variable at a dotted key path.
+func (in *interpolator) lookup(path string) (interface{}, bool, error) {
+	keys, v, found := find(map[string]interface{}(in.vars), strings.Split(path, "."), nil)
+	if !found {
+		return nil, false, nil
+	}
+	v, err := in.walk(keys, v)
+	return v, err == nil, err
+}
+
+// expand expands the references in s, where from names what s is for errors.
+func (in *interpolator) expand(from, s string) (interface{}, error) {
+	b := new(bytes.Buffer)
+	for rest := s; rest != ""; {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+		if i > 0 && rest[i-1] == '$' {
+			b.WriteString(rest[:i-1] + "${")
+			rest = rest[i+2:]
+			continue
+		}
+		b.WriteString(rest[:i])
+
+		m := reference.FindStringSubmatch(rest[i:])
+		if m == nil {
+			b.WriteString("${")
+			rest = rest[i+2:]
+			continue
+		}
+
+		v, found, err := in.lookup(m[1])
+		if err != nil {
+			return nil, err
+		} else if !found {
+			return nil, fmt.Errorf("%s references unknown variable %q", from, m[1])
+		}
+
+		// a lone reference keeps the type of what it refers to
+		if len(m[0]) == len(s) {
+			return v, nil
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s cannot interpolate variable %q into a string - it is a table or array", from, m[1])
+		}
+		fmt.Fprint(b, v)
+		rest = rest[i+len(m[0]):]
+	}
+	return b.String(), nil
+}
+
+// String expands the references in s, where from names what s is for errors.
+func (in *interpolator) String(from, s string) (string, error) {
+	v, err := in.expand(from, s)
+	if err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("%s cannot be a table or array", from)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// Interpolate returns a copy of v with ${name} references in its strings
+// expanded against v itself.
+func (v VariableMap) Interpolate() (VariableMap, error) {
+	in := newInterpolator(v)
+	out := make(VariableMap, len(v))
+	for k, child := range v {
+		ev, err := in.walk([]string{k}, child)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = ev
+	}
+	return out, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVariableMapInterpolate(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		vars     VariableMap
+		expected VariableMap
+	}{
+		{
+			name:     "plain",
+			vars:     VariableMap{"host": "example.com", "port": int64(8080), "url": "https://${host}:${port}/api"},
+			expected: VariableMap{"host": "example.com", "port": int64(8080), "url": "https://example.com:8080/api"},
+		},
+		{
+			name:     "chained",
+			vars:     VariableMap{"a": "${b}/a", "b": "${c}/b", "c": "c"},
+			expected: VariableMap{"a": "c/b/a", "b": "c/b", "c": "c"},
+		},
+		{
+			name: "key paths",
+			vars: VariableMap{
+				"site": map[string]interface{}{"host": "example.com"},
+				"url":  "https://${ site.host }/",
+			},
+			expected: VariableMap{
+				"site": map[string]interface{}{"host": "example.com"},
+				"url":  "https://example.com/",
+			},
+		},
+		{
+			name: "nested",
+			vars: VariableMap{
+				"name": "yate",
+				"nav":  map[string]interface{}{"title": "${name}", "links": []interface{}{"/${name}", "/"}},
+			},
+			expected: VariableMap{
+				"name": "yate",
+				"nav":  map[string]interface{}{"title": "yate", "links": []interface{}{"/yate", "/"}},
+			},
+		},
+		{
+			name:     "lone reference keeps type",
+			vars:     VariableMap{"port": int64(8080), "listen": "${port}", "tags": []interface{}{"a"}, "copy": "${tags}"},
+			expected: VariableMap{"port": int64(8080), "listen": int64(8080), "tags": []interface{}{"a"}, "copy": []interface{}{"a"}},
+		},
+		{
+			name:     "escaped",
+			vars:     VariableMap{"a": "$${a} and ${b}", "b": "b"},
+			expected: VariableMap{"a": "${a} and b", "b": "b"},
+		},
+		{
+			name:     "not references",
+			vars:     VariableMap{"step": "echo ${{ github.sha }}", "a": "x${", "b": "x${}", "c": "${ not a path }"},
+			expected: VariableMap{"step": "echo ${{ github.sha }}", "a": "x${", "b": "x${}", "c": "${ not a path }"},
+		},
+		{
+			name: "dotted keys",
+			vars: VariableMap{
+				"app.kubernetes.io/name": "web",
+				"labels":                 map[string]interface{}{"app.kubernetes.io/part-of": "site"},
+				"site.name":              "plain",
+			},
+			expected: VariableMap{
+				"app.kubernetes.io/name": "web",
+				"labels":                 map[string]interface{}{"app.kubernetes.io/part-of": "site"},
+				"site.name":              "plain",
+			},
+		},
+		{
+			name: "dotted key references",
+			vars: VariableMap{
+				"site.host": "example.com",
+				"labels":    map[string]interface{}{"app.name": "web"},
+				"url":       "https://${site.host}/${labels.app.name}",
+			},
+			expected: VariableMap{
+				"site.host": "example.com",
+				"labels":    map[string]interface{}{"app.name": "web"},
+				"url":       "https://example.com/web",
+			},
+		},
+		{
+			name: "array of tables",
+			vars: VariableMap{
+				"host": "example.com",
+				"services": []interface{}{
+					map[string]interface{}{"name": "api", "url": "https://${host}/api"},
+					map[string]interface{}{"name": "web", "port": int64(80)},
+				},
+				"first": "${services.0.name}",
+			},
+			expected: VariableMap{
+				"host": "example.com",
+				"services": []interface{}{
+					map[string]interface{}{"name": "api", "url": "https://example.com/api"},
+					map[string]interface{}{"name": "web", "port": int64(80)},
+				},
+				"first": "api",
+			},
+		},
+	} {
+		vars, err := test.vars.Interpolate()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("%s: incorrect variables - expected %v, got %v", test.name, test.expected, vars)
+		}
+	}
+}
+
+func TestVariableMapInterpolateErrors(t *testing.T) {
+	for _, test := range []struct {
+		vars     VariableMap
+		expected string
+	}{
+		{VariableMap{"a": "${missing}"}, `variable "a" references unknown variable "missing"`},
+		{VariableMap{"s": []interface{}{map[string]interface{}{"a": "${missing}"}}}, `variable "s.0.a" references unknown variable "missing"`},
+		{VariableMap{"a": "x${b}", "b": map[string]interface{}{}}, `variable "a" cannot interpolate variable "b" into a string - it is a table or array`},
+		{VariableMap{"a": "${a}"}, "Cycle detected in variable interpolation: a -> a"},
+		{VariableMap{"nav": map[string]interface{}{"self": "${nav}"}}, "Cycle detected in variable interpolation: nav -> nav.self -> nav"},
+		{VariableMap{"tags": []interface{}{"${tags}"}}, "Cycle detected in variable interpolation: tags -> tags.0 -> tags"},
+	} {
+		_, err := test.vars.Interpolate()
+		if err == nil {
+			t.Errorf("expected error %q, got none", test.expected)
+		} else if err.Error() != test.expected {
+			t.Errorf("incorrect error - expected %q, got %q", test.expected, err.Error())
+		}
+	}
+}
+
+func TestInterpolationCycle(t *testing.T) {
+	_, err := VariableMap{"a": "${b}", "b": "x${c}", "c": "${a}"}.Interpolate()
+	cycle, is := err.(*InterpolationCycleError)
+	if !is {
+		t.Fatalf("expected an interpolation cycle, got %v", err)
+	}
+	// whichever variable is expanded first starts the cycle
+	if len(cycle.Stack) != 4 || cycle.Stack[0] != cycle.Stack[3] {
+		t.Errorf("incorrect cycle %v", cycle.Stack)
+	}
+}
+
+func TestTomlConfigSourceInterpolation(t *testing.T) {
+	src, err := ParseTomlConfigSource("yate.toml", []byte(`
+[globals]
+host = "example.com"
+
+[resources.a]
+template = "a.tpl"
+
+[resources.a.variables]
+"app.kubernetes.io/name" = "web"
+step = "echo ${{ github.sha }}"
+
+[[resources.a.variables.services]]
+name = "api"
+url = "https://${host}/api"
+
+[[resources.a.variables.services]]
+name = "web"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b := NewBuilder(src, staticResolver{}, nil)
+
+	cfg, err := b.Config("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	vars, err := b.Variables(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := VariableMap{
+		"host":                   "example.com",
+		"app.kubernetes.io/name": "web",
+		"step":                   "echo ${{ github.sha }}",
+		"services": []interface{}{
+			map[string]interface{}{"name": "api", "url": "https://example.com/api"},
+			map[string]interface{}{"name": "web"},
+		},
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("incorrect variables - expected %v, got %v", expected, vars)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+type CyclicalLayoutError struct {
+	Stack []string
+}
+
+func (c *CyclicalLayoutError) Error() string {
+	return "Cycle detected in layout path: " + strings.Join(c.Stack, " -> ")
+}
+
+// the template funcs that resolve paths relative to the component they are
+// called from
+var relativeFuncs = []string{"include", "capture", "indentInclude", "nestInclude", "readFile", "loadData", "glob"}
+
+// Extends returns the path of the layout the component extends, relative to
+// the component, or "" if it doesn't extend one.
+func (c *Component) Extends() (string, error) {
+	h, err := c.parsedHeader()
+	return h.extends, err
+}
+
+// renames identifiers throughout a parse tree
+func renameIdentifiers(n parse.Node, names map[string]string) {
+	switch v := n.(type) {
+	case *parse.IdentifierNode:
+		if name, h := names[v.Ident]; h {
+			v.Ident = name
+		}
+	case *parse.ListNode:
+		for _, c := range v.Nodes {
+			renameIdentifiers(c, names)
+		}
+	case *parse.ActionNode:
+		renameIdentifiers(v.Pipe, names)
+	case *parse.PipeNode:
+		for _, c := range v.Cmds {
+			renameIdentifiers(c, names)
+		}
+	case *parse.CommandNode:
+		for _, c := range v.Args {
+			renameIdentifiers(c, names)
+		}
+	case *parse.ChainNode:
+		renameIdentifiers(v.Node, names)
+	case *parse.TemplateNode:
+		if v.Pipe != nil {
+			renameIdentifiers(v.Pipe, names)
+		}
+	case *parse.IfNode:
+		renameBranch(&v.BranchNode, names)
+	case *parse.RangeNode:
+		renameBranch(&v.BranchNode, names)
+	case *parse.WithNode:
+		renameBranch(&v.BranchNode, names)
+	}
+}
+
+func renameBranch(b *parse.BranchNode, names map[string]string) {
+	renameIdentifiers(b.Pipe, names)
+	renameIdentifiers(b.List, names)
+	if b.ElseList != nil {
+		renameIdentifiers(b.ElseList, names)
+	}
+}
+
+// RenderInLayouts renders the component inside the layouts it extends, given
+// from the layout it extends directly up to the root-most one.
+//
+// The root-most layout is what gets rendered, with the blocks each component
+// beneath it defines replacing its own, so a block falls back to whichever
+// layout closest to the component last defined it. Anything in a component
+// that extends a layout other than its header and blocks is ignored. The
+// front matter variables of each layout are filled in beneath those of the
+// component, nearest layout first.
+func (c *Component) RenderInLayouts(ctx RenderContext, layouts []*Component, args ...interface{}) error {
+	v, err := c.renderData(ctx, args)
+	if err != nil {
+		return err
+	}
+	for _, l := range layouts {
+		if v["Vars"], err = l.localVariables(v["Vars"]); err != nil {
+			return err
+		}
+	}
+
+	root := layouts[len(layouts)-1]
+	t, err := root.Clone()
+	if err != nil {
+		return err
+	}
+	funcs := root.funcs(ctx)
+	t.Funcs(funcs)
+
+	overlays := make([]*Component, 0, len(layouts))
+	for i := len(layouts) - 2; i >= 0; i-- {
+		overlays = append(overlays, layouts[i])
+	}
+	overlays = append(overlays, c)
+
+	for i, o := range overlays {
+		// blocks are executed as part of the root layout, so funcs that
+		// depend on which file they're in are renamed to copies bound to
+		// the file that defined the block
+		bound := o.funcs(ctx)
+		renames := make(map[string]string)
+		aliases := make(template.FuncMap)
+		for _, name := range relativeFuncs {
+			alias := name + "__layout" + strconv.Itoa(i)
+			renames[name] = alias
+			aliases[alias] = bound[name]
+			funcs[alias] = bound[name]
+		}
+		t.Funcs(aliases)
+
+		for _, d := range o.Templates() {
+			if d.Name() == "" || d.Tree == nil {
+				continue
+			}
+			tree := d.Tree.Copy()
+			renameIdentifiers(tree.Root, renames)
+			if _, err := t.AddParseTree(d.Name(), tree); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the component decides how the page is escaped, falling back to the
+	// layouts nearest it
+	escape := Escaping("")
+	for _, o := range append([]*Component{c}, layouts...) {
+		if escape = o.Meta.Escape; escape != "" {
+			break
+		}
+	}
+	if escape == "" {
+		escape = ctx.Escaping()
+	}
+	return execute(ctx, escape, t, funcs, v)
+}
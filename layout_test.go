@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var layoutComponents = staticResolver{
+	"layouts/base.tpl": "<{{ block \"content\" . }}base content{{ end }}|{{ block \"sidebar\" . }}base sidebar{{ end }}>",
+	"layouts/mid.tpl": "{{ extends \"base.tpl\" }}mid body is ignored" +
+		"{{ define \"sidebar\" }}mid sidebar{{ end }}{{ define \"content\" }}mid content{{ end }}",
+	"layouts/vars.tpl":   "{{ .Vars.v }}[{{ block \"content\" . }}{{ end }}]",
+	"layouts/part.tpl":   "layout part",
+	"pages/part.tpl":     "page part",
+	"pages/plain.tpl":    "{{ extends \"../layouts/base.tpl\" }}",
+	"pages/content.tpl":  "{{ extends \"../layouts/base.tpl\" }}{{ define \"content\" }}page content{{ end }}",
+	"pages/deep.tpl":     "{{ extends \"../layouts/mid.tpl\" }}{{ define \"content\" }}deep content{{ end }}",
+	"pages/deepbase.tpl": "{{ extends \"../layouts/mid.tpl\" }}",
+	"pages/vars.tpl": "{{ param \"title\" \"untitled\" }}{{ extends \"../layouts/vars.tpl\" }}" +
+		"{{ define \"content\" }}{{ .Args.title }} {{ .Vars.v }}{{ end }}",
+	"pages/include.tpl":  "{{ extends \"../layouts/vars.tpl\" }}{{ define \"content\" }}{{ include \"part.tpl\" }}{{ end }}",
+	"pages/cycle.tpl":    "{{ extends \"../layouts/cycle1.tpl\" }}",
+	"layouts/cycle1.tpl": "{{ extends \"cycle2.tpl\" }}",
+	"layouts/cycle2.tpl": "{{ extends \"cycle1.tpl\" }}",
+	"pages/missing.tpl":  "{{ extends \"nothing.tpl\" }}",
+}
+
+var layoutTests = []struct {
+	component string
+	args      []interface{}
+	expected  string
+}{
+	{"pages/plain.tpl", nil, "<base content|base sidebar>"},
+	{"pages/content.tpl", nil, "<page content|base sidebar>"},
+	{"pages/deep.tpl", nil, "<deep content|mid sidebar>"},
+	{"pages/deepbase.tpl", nil, "<mid content|mid sidebar>"},
+	{"pages/vars.tpl", nil, "value[untitled value]"},
+	{"pages/vars.tpl", []interface{}{"title", "Hello"}, "value[Hello value]"},
+	{"pages/include.tpl", nil, "value[page part]"},
+}
+
+func TestRenderScopeLayouts(t *testing.T) {
+	for _, tt := range layoutTests {
+		t.Run(tt.component, func(t *testing.T) {
+			b := new(bytes.Buffer)
+			scope := NewRenderScope(b, layoutComponents, staticResolver{}, "", map[string]string{"v": "value"})
+			if err := scope.Render(tt.component, tt.args...); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("incorrect result - expected %s, got %s", tt.expected, b.String())
+			}
+		})
+	}
+}
+
+func TestRenderScopeLayoutErrors(t *testing.T) {
+	scope := NewRenderScope(new(bytes.Buffer), layoutComponents, staticResolver{}, "", struct{}{})
+
+	err := scope.Render("pages/cycle.tpl")
+	expected := &CyclicalLayoutError{[]string{"pages/cycle.tpl", "layouts/cycle1.tpl", "layouts/cycle2.tpl", "layouts/cycle1.tpl"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("incorrect error - expected %v, got %v", expected, err)
+	}
+	if err != nil && err.Error() != "Cycle detected in layout path: pages/cycle.tpl -> layouts/cycle1.tpl -> layouts/cycle2.tpl -> layouts/cycle1.tpl" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+
+	err = scope.Render("pages/missing.tpl")
+	if err != notExist {
+		t.Errorf("incorrect error - expected %v, got %v", notExist, err)
+	}
+}
+
+var componentExtendsErrorTests = []struct {
+	template string
+	err      ComponentParseError
+}{
+	{"{{ extends }}", ComponentParseError{"c.tpl", 1, 4, "extends takes the path of a layout"}},
+	{"{{ extends .x }}", ComponentParseError{"c.tpl", 1, 4, "extends takes the path of a layout"}},
+	{"{{ extends \"a\" }}{{ extends \"b\" }}", ComponentParseError{"c.tpl", 1, 21, "a component can only extend one layout"}},
+	{"text {{ extends \"a\" }}", ComponentParseError{"c.tpl", 1, 9, "extends must be declared at the top of the component"}},
+	{"{{ define \"content\" }}{{ extends \"a\" }}{{ end }}", ComponentParseError{"c.tpl", 1, 26, "extends must be declared at the top of the component"}},
+}
+
+func TestComponentExtendsErrors(t *testing.T) {
+	for _, tt := range componentExtendsErrorTests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := ParseComponent("c.tpl", tt.template)
+			pe, is := err.(*ComponentParseError)
+			if !is {
+				t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+			}
+			if *pe != tt.err {
+				t.Errorf("incorrect error - expected %v, got %v", &tt.err, pe)
+			}
+		})
+	}
+}
+
+func TestRenderScopeLayoutVariables(t *testing.T) {
+	files := map[string]string{
+		"layouts/base.tpl": "+++ yate\n[variables]\nfooter = \"default footer\"\ntitle = \"base\"\nsub = \"base\"\n+++\n" +
+			"{{ block \"content\" . }}{{ end }}|{{ .Vars.title }}|{{ .Vars.sub }}|{{ .Vars.footer }}",
+		"layouts/mid.tpl": "+++ yate\n[variables]\ntitle = \"mid\"\nsub = \"mid\"\n+++\n{{ extends \"base.tpl\" }}",
+		"pages/page.tpl":  "+++ yate\n[variables]\nsub = \"page\"\n+++\n{{ extends \"../layouts/mid.tpl\" }}{{ define \"content\" }}{{ .Vars.footer }}{{ end }}",
+	}
+	components := NewCacheComponentResolver(func(path string) ([]byte, error) {
+		return []byte(files[path]), nil
+	})
+
+	for _, tt := range []struct {
+		component string
+		vars      VariableMap
+		expected  string
+	}{
+		{"layouts/base.tpl", VariableMap{}, "|base|base|default footer"},
+		{"pages/page.tpl", VariableMap{}, "default footer|mid|page|default footer"},
+		{"pages/page.tpl", VariableMap{"footer": "resource"}, "resource|mid|page|resource"},
+	} {
+		b := new(bytes.Buffer)
+		scope := NewRenderScope(b, components, staticResolver{}, "", tt.vars)
+		if err := scope.Render(tt.component); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if b.String() != tt.expected {
+			t.Errorf("incorrect result for %s - expected %q, got %q", tt.component, tt.expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnvVarPrefix starts the names of environment variables that set variables,
+// as YATE_VAR_<key path> with the keys of the path separated by "__", so
+// YATE_VAR_nav__home sets nav.home.
+const EnvVarPrefix = "YATE_VAR_"
+
+// Overrides are variables set from outside the config file, by environment
+// variables and --var flags. They are merged over the variables of every
+// resource once globals are merged in, so they win over both, and their
+// values are always strings.
+type Overrides struct {
+	Variables  VariableMap
+	Provenance Provenance
+}
+
+func NewOverrides() *Overrides {
+	return &Overrides{
+		Variables:  make(VariableMap),
+		Provenance: make(Provenance),
+	}
+}
+
+// SetEnv sets variables from environment variables, given as name=value as
+// os.Environ returns them, whose names start with EnvVarPrefix.
+func (o *Overrides) SetEnv(environ []string) {
+	for _, e := range environ {
+		i := strings.IndexByte(e, '=')
+		if i < 0 || !strings.HasPrefix(e[:i], EnvVarPrefix) {
+			continue
+		}
+		name := e[:i]
+		path := strings.Split(name[len(EnvVarPrefix):], "__")
+		if !validKeyPath(path) {
+			continue
+		}
+		o.set(path, e[i+1:], Origin{Kind: OriginEnv, Name: name})
+	}
+}
+
+// SetFlags sets variables from --var flags, given as key=value where key is
+// a dotted key path. Flags win over environment variables set before them.
+func (o *Overrides) SetFlags(vars []string) error {
+	for _, v := range vars {
+		i := strings.IndexByte(v, '=')
+		if i < 0 {
+			return fmt.Errorf("--var %s: expected key=value", v)
+		}
+		path := strings.Split(v[:i], ".")
+		if !validKeyPath(path) {
+			return fmt.Errorf("--var %s: %q is not a key path", v, v[:i])
+		}
+		o.set(path, v[i+1:], Origin{Kind: OriginFlag, Name: v[:i]})
+	}
+	return nil
+}
+
+func validKeyPath(path []string) bool {
+	for _, k := range path {
+		if k == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// set sets the variable at path, replacing anything that isn't a table on
+// the way to it.
+func (o *Overrides) set(path []string, value string, origin Origin) {
+	m := map[string]interface{}(o.Variables)
+	for i, k := range path[:len(path)-1] {
+		child, is := m[k].(map[string]interface{})
+		if !is {
+			child = make(map[string]interface{})
+			m[k] = child
+			delete(o.Provenance, strings.Join(path[:i+1], "."))
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+
+	leaf := strings.Join(path, ".")
+	o.Provenance.take(leaf, Provenance{leaf: {origin}})
+}
+
+// merge merges o over vars, whose leaves were set at prov, returning the
+// result and where each of its leaves was set.
+func (o *Overrides) merge(vars VariableMap, prov Provenance) (VariableMap, Provenance, error) {
+	if o == nil {
+		return vars, prov, nil
+	}
+	merged := o.Provenance.Copy()
+	vars, err := o.Variables.Copy().MergeTracked(merged, vars, prov, MergeOptions{})
+	return vars, merged, err
+}
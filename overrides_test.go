@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		env      []string
+		flags    []string
+		vars     VariableMap
+		expected Provenance
+	}{
+		{
+			name: "env",
+			env:  []string{"HOME=/root", "YATE_VAR_title=env", "YATE_VAR_nav__home=/", "YATE_VAR_=x", "YATE_VAR_a____b=x", "yate_var_lower=x"},
+			vars: VariableMap{"title": "env", "nav": map[string]interface{}{"home": "/"}},
+			expected: Provenance{
+				"title":    {{Kind: OriginEnv, Name: "YATE_VAR_title"}},
+				"nav.home": {{Kind: OriginEnv, Name: "YATE_VAR_nav__home"}},
+			},
+		},
+		{
+			name:  "flags win",
+			env:   []string{"YATE_VAR_title=env", "YATE_VAR_nav__home=/"},
+			flags: []string{"title=flag", "nav.about=/about", "query=a=b", "empty="},
+			vars:  VariableMap{"title": "flag", "nav": map[string]interface{}{"home": "/", "about": "/about"}, "query": "a=b", "empty": ""},
+			expected: Provenance{
+				"title":     {{Kind: OriginFlag, Name: "title"}},
+				"nav.home":  {{Kind: OriginEnv, Name: "YATE_VAR_nav__home"}},
+				"nav.about": {{Kind: OriginFlag, Name: "nav.about"}},
+				"query":     {{Kind: OriginFlag, Name: "query"}},
+				"empty":     {{Kind: OriginFlag, Name: "empty"}},
+			},
+		},
+		{
+			name:     "table replaced",
+			env:      []string{"YATE_VAR_nav__home=/"},
+			flags:    []string{"nav=none", "title=t", "title.main=t"},
+			vars:     VariableMap{"nav": "none", "title": map[string]interface{}{"main": "t"}},
+			expected: Provenance{"nav": {{Kind: OriginFlag, Name: "nav"}}, "title.main": {{Kind: OriginFlag, Name: "title.main"}}},
+		},
+	} {
+		o := NewOverrides()
+		o.SetEnv(test.env)
+		if err := o.SetFlags(test.flags); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(o.Variables, test.vars) {
+			t.Errorf("%s: incorrect variables - expected %v, got %v", test.name, test.vars, o.Variables)
+		}
+		if !reflect.DeepEqual(o.Provenance, test.expected) {
+			t.Errorf("%s: incorrect provenance - expected %v, got %v", test.name, test.expected, o.Provenance)
+		}
+	}
+}
+
+func TestOverridesFlagErrors(t *testing.T) {
+	for _, test := range []struct {
+		flag     string
+		expected string
+	}{
+		{"title", "--var title: expected key=value"},
+		{"=x", `--var =x: "" is not a key path`},
+		{"nav..home=x", `--var nav..home=x: "nav..home" is not a key path`},
+	} {
+		err := NewOverrides().SetFlags([]string{test.flag})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("incorrect error - expected %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestBuilderOverrides(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"host": "example.com", "nav": map[string]interface{}{"home": "/"}},
+		resources: map[string]ResourceConfig{
+			"a": {Template: "a.tpl", Output: "dist/${name}.html", Variables: VariableMap{"name": "a", "title": "a", "url": "https://${host}/"}},
+		},
+	}
+	b := NewBuilder(src, staticResolver{"a.tpl": "{{ .Vars.title }} {{ .Vars.url }} {{ .Vars.nav.home }}"}, nil)
+	b.Overrides = NewOverrides()
+	b.Overrides.SetEnv([]string{"YATE_VAR_title=env", "YATE_VAR_nav__home=/home"})
+	if err := b.Overrides.SetFlags([]string{"name=b", "host=localhost"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := b.Config("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Output != "dist/b.html" {
+		t.Errorf("incorrect output - expected dist/b.html, got %s", cfg.Output)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := b.Render(buf, "a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != "env https://localhost/ /home" {
+		t.Errorf("incorrect result - expected %q, got %q", "env https://localhost/ /home", buf.String())
+	}
+
+	_, prov, err := b.Explain("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a := Origin{Kind: OriginResource, Name: "a"}
+	expected := Provenance{
+		"host":     {{Kind: OriginFlag, Name: "host"}},
+		"name":     {{Kind: OriginFlag, Name: "name"}},
+		"nav.home": {{Kind: OriginEnv, Name: "YATE_VAR_nav__home"}},
+		"title":    {{Kind: OriginEnv, Name: "YATE_VAR_title"}},
+		"url":      {a},
+	}
+	if !reflect.DeepEqual(prov, expected) {
+		t.Errorf("incorrect provenance - expected %v, got %v", expected, prov)
+	}
+}
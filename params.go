@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Param is a named argument a component declares at its top with
+//
+//	{{ param "title" }}
+//	{{ param "body" "default body" }}
+//
+// A param declared without a default is required.
+type Param struct {
+	Name     string
+	Default  interface{}
+	Required bool
+}
+
+// ComponentArgumentError is a problem with the named arguments a component
+// was rendered with.
+type ComponentArgumentError struct {
+	Filepath string
+	Argument string
+	Problem  string
+}
+
+func (c *ComponentArgumentError) Error() string {
+	if c.Argument == "" {
+		return c.Filepath + ": " + c.Problem
+	}
+	return c.Filepath + ": " + c.Problem + " \"" + c.Argument + "\""
+}
+
+func (c *Component) Params() ([]Param, error) {
+	h, err := c.parsedHeader()
+	return h.params, err
+}
+
+// keywordArgs interprets render arguments as named arguments, either as a
+// single map or as alternating names and values.
+func keywordArgs(args []interface{}) (map[string]interface{}, bool) {
+	named := make(map[string]interface{})
+	if len(args) == 1 {
+		m := reflect.ValueOf(args[0])
+		if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+			return named, false
+		}
+		for _, k := range m.MapKeys() {
+			named[k.String()] = m.MapIndex(k).Interface()
+		}
+		return named, true
+	}
+
+	if len(args)%2 != 0 {
+		return named, false
+	}
+	for i := 0; i < len(args); i += 2 {
+		k, is := args[i].(string)
+		if !is {
+			return map[string]interface{}{}, false
+		}
+		named[k] = args[i+1]
+	}
+	return named, true
+}
+
+// namedArgs works out the named arguments of a render. Components without
+// params take whatever named arguments they are given; those with params
+// must be given only named arguments that match them.
+func (c *Component) namedArgs(args []interface{}) (map[string]interface{}, error) {
+	named, isNamed := keywordArgs(args)
+
+	params, err := c.Params()
+	if err != nil || len(params) == 0 {
+		return named, err
+	}
+
+	if !isNamed && len(args) > 0 {
+		return nil, &ComponentArgumentError{
+			Filepath: c.Filepath,
+			Problem:  "takes named arguments, but was given " + strconv.Itoa(len(args)) + " positional arguments",
+		}
+	}
+
+	declared := make(map[string]struct{})
+	for _, p := range params {
+		declared[p.Name] = struct{}{}
+		if _, h := named[p.Name]; h {
+			continue
+		}
+		if p.Required {
+			return nil, &ComponentArgumentError{
+				Filepath: c.Filepath,
+				Argument: p.Name,
+				Problem:  "missing required argument",
+			}
+		}
+		named[p.Name] = p.Default
+	}
+
+	unknown := make([]string, 0)
+	for k := range named {
+		if _, h := declared[k]; !h {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, &ComponentArgumentError{
+			Filepath: c.Filepath,
+			Argument: unknown[0],
+			Problem:  "unknown argument",
+		}
+	}
+
+	return named, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var componentParamsTests = []struct {
+	template string
+	params   []Param
+}{
+	{"no params", []Param{}},
+	{"", []Param{}},
+	{"{{ param \"a\" }}", []Param{{"a", nil, true}}},
+	{"{{/* card */}}\n{{ param \"a\" }}\n  {{- param \"b\" \"x\" }}\n{{ param \"c\" 3 }}{{ param \"d\" true }}{{ param \"e\" nil }}{{ .Args.a }}",
+		[]Param{{"a", nil, true}, {"b", "x", false}, {"c", 3, false}, {"d", true, false}, {"e", nil, false}}},
+}
+
+func TestComponentParams(t *testing.T) {
+	for _, tt := range componentParamsTests {
+		t.Run(tt.template, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			params, err := c.Params()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(params, tt.params) {
+				t.Errorf("incorrect params - expected %v, got %v", tt.params, params)
+			}
+		})
+	}
+}
+
+var componentParamsErrorTests = []struct {
+	template string
+	err      ComponentParseError
+}{
+	{"{{ param }}", ComponentParseError{"c.tpl", 1, 4, "param takes a name and an optional default"}},
+	{"{{ param \"a\" 1 2 }}", ComponentParseError{"c.tpl", 1, 4, "param takes a name and an optional default"}},
+	{"{{ param .x }}", ComponentParseError{"c.tpl", 1, 4, "param name must be a string"}},
+	{"{{ param \"a\" .x }}", ComponentParseError{"c.tpl", 1, 4, "param default must be a constant"}},
+	{"{{ param \"a\" }}\n{{ param \"a\" 1 }}", ComponentParseError{"c.tpl", 2, 4, "param \"a\" is declared more than once"}},
+	{"text\n{{ param \"a\" }}", ComponentParseError{"c.tpl", 2, 4, "param must be declared at the top of the component"}},
+	{"{{ if .x }}\n  {{ param \"a\" }}\n{{ end }}", ComponentParseError{"c.tpl", 2, 6, "param must be declared at the top of the component"}},
+	{"{{ define \"x\" }}\n{{ param \"a\" }}{{ end }}", ComponentParseError{"c.tpl", 2, 4, "param must be declared at the top of the component"}},
+	{"{{ block \"x\" . }}{{ if .x }}{{ param \"a\" }}{{ end }}{{ end }}", ComponentParseError{"c.tpl", 1, 32, "param must be declared at the top of the component"}},
+}
+
+func TestComponentParamsErrors(t *testing.T) {
+	for _, tt := range componentParamsErrorTests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := ParseComponent("c.tpl", tt.template)
+			pe, is := err.(*ComponentParseError)
+			if !is {
+				t.Fatalf("expected a *ComponentParseError, got %T: %v", err, err)
+			}
+			if *pe != tt.err {
+				t.Errorf("incorrect error - expected %v, got %v", &tt.err, pe)
+			}
+		})
+	}
+}
+
+var componentNamedArgsTests = []struct {
+	name     string
+	template string
+	args     []interface{}
+	result   string
+	err      string
+}{
+	{"pairs", "{{ .Args.title }}: {{ .Args.body }}", []interface{}{"title", "Hello", "body", 3}, "Hello: 3", ""},
+	{"dict", "{{ .Args.title }}: {{ .Args.body }}", []interface{}{map[string]interface{}{"title": "Hello", "body": 3}}, "Hello: 3", ""},
+	{"variable map", "{{ .Args.title }}", []interface{}{VariableMap{"title": "Hello"}}, "Hello", ""},
+	{"positional still works", "{{ .Arg0 }} {{ .Arg1 }}", []interface{}{"title", "Hello"}, "title Hello", ""},
+	{"positional has no names", "{{ len .Args }}", []interface{}{1, 2}, "0", ""},
+	{"declared", "{{- param \"title\" }}{{- param \"body\" \"none\" -}}\n{{ .Args.title }}: {{ .Args.body }}", []interface{}{"title", "Hello"}, "Hello: none", ""},
+	{"declared overridden", "{{- param \"title\" }}{{- param \"body\" \"none\" -}}\n{{ .Args.title }}: {{ .Args.body }}", []interface{}{"body", "x", "title", "Hello"}, "Hello: x", ""},
+	{"declared dict", "{{- param \"title\" -}}\n{{ .Args.title }}", []interface{}{map[string]string{"title": "Hello"}}, "Hello", ""},
+	{"missing", "{{- param \"title\" }}{{- param \"body\" \"none\" -}}", []interface{}{"body", "x"}, "", "c.tpl: missing required argument \"title\""},
+	{"unknown", "{{- param \"title\" -}}", []interface{}{"title", "x", "titel", "y"}, "", "c.tpl: unknown argument \"titel\""},
+	{"positional to declared", "{{- param \"title\" -}}", []interface{}{"x"}, "", "c.tpl: takes named arguments, but was given 1 positional arguments"},
+}
+
+func TestComponentNamedArgs(t *testing.T) {
+	for _, tt := range componentNamedArgsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseComponent("c.tpl", tt.template)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+
+			rctx := &testRCtx{b: new(bytes.Buffer)}
+			err = c.Render(rctx, tt.args...)
+			if tt.err != "" {
+				if _, is := err.(*ComponentArgumentError); !is || err.Error() != tt.err {
+					t.Fatalf("incorrect error - expected %s, got %T: %v", tt.err, err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rctx.b.String() != tt.result {
+				t.Errorf("incorrect result - expected %s, got %s", tt.result, rctx.b.String())
+			}
+		})
+	}
+}
+
+func TestIncludeNamedArgs(t *testing.T) {
+	r := staticResolver{
+		"page": "{{ include \"card\" \"title\" \"Hello\" \"body\" .Vars.v }}",
+		"card": "{{- param \"title\" }}{{ param \"body\" }}{{ param \"footer\" \"-\" -}}\n[{{ .Args.title }}|{{ .Args.body }}|{{ .Args.footer }}]",
+	}
+
+	b := new(bytes.Buffer)
+	scope := NewRenderScope(b, r, staticResolver{}, "", map[string]string{"v": "world"})
+	if err := scope.Render("page"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b.String() != "[Hello|world|-]" {
+		t.Errorf("incorrect result - expected %s, got %s", "[Hello|world|-]", b.String())
+	}
+}
+
+func TestParseComponentReadsHeaderOnce(t *testing.T) {
+	c, err := ParseComponent("c.tpl", "{{ extends \"l.tpl\" }}{{ param \"title\" }}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.header == nil {
+		t.Fatalf("header was not read when parsing")
+	}
+
+	// only the header read at parse time is used from then on
+	c.Tree = nil
+	params, err := c.Params()
+	if err != nil || len(params) != 1 || params[0].Name != "title" {
+		t.Errorf("incorrect params - expected title, got %v (%v)", params, err)
+	}
+	if extends, err := c.Extends(); err != nil || extends != "l.tpl" {
+		t.Errorf("incorrect layout - expected l.tpl, got %q (%v)", extends, err)
+	}
+}
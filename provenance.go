@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// OriginKind is the kind of place a variable can be set.
+type OriginKind string
+
+const (
+	OriginGlobal   OriginKind = "global"
+	OriginResource OriginKind = "resource"
+	OriginEnv      OriginKind = "env"
+	OriginFlag     OriginKind = "flag"
+)
+
+// Origin is where a variable was set. Name is the resource, environment
+// variable or --var key that set it, if any, and File, Line and Col are where in a file it was set, where that is
+// known.
+type Origin struct {
+	Kind      OriginKind
+	Name      string
+	File      string
+	Line, Col int
+}
+
+func (o Origin) String() string {
+	var s string
+	switch o.Kind {
+	case OriginGlobal:
+		s = "globals"
+	case OriginResource:
+		s = fmt.Sprintf("resource %q", o.Name)
+	case OriginEnv:
+		s = "environment variable " + o.Name
+	case OriginFlag:
+		s = "flag --var " + o.Name
+	default:
+		s = string(o.Kind)
+	}
+
+	if o.File != "" && o.Line != 0 {
+		s += fmt.Sprintf(" at %s:%d:%d", o.File, o.Line, o.Col)
+	} else if o.File != "" {
+		s += " in " + o.File
+	}
+	return s
+}
+
+// Provenance is where each leaf of a variable map was set, keyed by its
+// dotted key path. A leaf is anything other than a non-empty map. Lists that
+// were merged together have the origins of each list they were merged from,
+// in the order their items are in.
+type Provenance map[string][]Origin
+
+// Copy returns a copy of p.
+func (p Provenance) Copy() Provenance {
+	c := make(Provenance, len(p))
+	for k, o := range p {
+		c[k] = append([]Origin{}, o...)
+	}
+	return c
+}
+
+func pathUnder(p, path string) bool {
+	return p == path || strings.HasPrefix(p, path+".")
+}
+
+// take replaces the origins of path and everything beneath it with those in
+// from.
+func (p Provenance) take(path string, from Provenance) {
+	for k := range p {
+		if pathUnder(k, path) {
+			delete(p, k)
+		}
+	}
+	for k, o := range from {
+		if pathUnder(k, path) {
+			p[k] = append([]Origin{}, o...)
+		}
+	}
+}
+
+// mergeList gives the list at path the origins of both of the lists merged
+// into it, in the order mergeLists puts their items.
+func (p Provenance) mergeList(path string, from Provenance, policy ListPolicy) {
+	first, second := from[path], p[path]
+	if policy == ListsPrepend {
+		first, second = second, first
+	}
+	p[path] = append(append([]Origin{}, first...), second...)
+}
+
+// walkLeaves calls f with the keys and value of every leaf in the map m.
+func walkLeaves(m reflect.Value, keys []string, f func(keys []string, v reflect.Value)) {
+	for _, k := range m.MapKeys() {
+		leaf := append(keys[:len(keys):len(keys)], fmt.Sprint(k.Interface()))
+		if v := elem(m.MapIndex(k)); kindIs(v, reflect.Map) && v.Len() > 0 {
+			walkLeaves(v, leaf, f)
+		} else {
+			f(leaf, m.MapIndex(k))
+		}
+	}
+}
+
+// Leaves returns the dotted key paths of every leaf in v, in sorted order.
+func (v VariableMap) Leaves() []string {
+	leaves := make([]string, 0, len(v))
+	walkLeaves(reflect.ValueOf(v), nil, func(keys []string, _ reflect.Value) {
+		leaves = append(leaves, strings.Join(keys, "."))
+	})
+	sort.Strings(leaves)
+	return leaves
+}
+
+// leafOrigins gives every leaf of v the same origin.
+func leafOrigins(v VariableMap, o Origin) Provenance {
+	p := make(Provenance)
+	for _, leaf := range v.Leaves() {
+		p[leaf] = []Origin{o}
+	}
+	return p
+}
+
+// ProvenanceConfigSource is a ResourceConfigSource that knows where each of
+// its variables was set.
+type ProvenanceConfigSource interface {
+	ResourceConfigSource
+	GlobalProvenance() Provenance
+	Provenance(resource string) Provenance
+}
+
+func globalProvenance(src ResourceConfigSource) Provenance {
+	if p, is := src.(ProvenanceConfigSource); is {
+		return p.GlobalProvenance().Copy()
+	}
+	return leafOrigins(src.GlobalVariables(), Origin{Kind: OriginGlobal})
+}
+
+func resourceProvenance(src ResourceConfigSource, resource string) Provenance {
+	if p, is := src.(ProvenanceConfigSource); is {
+		return p.Provenance(resource).Copy()
+	}
+	return leafOrigins(src.GetConfig(resource).Variables, Origin{Kind: OriginResource, Name: resource})
+}
+
+// Explain returns the variables a resource is rendered with, as Variables
+// does, along with where each of their leaves was set. The leaves of a table
+// copied by a lone ${name} reference were set where those of the table were.
+func (b *Builder) Explain(resource string) (VariableMap, Provenance, error) {
+	cfg, prov, err := NewInheritanceResolver(b.Source).ResolveProvenance(resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vars, err := cfg.Variables.Copy().MergeTracked(prov, b.Source.GlobalVariables().Copy(), globalProvenance(b.Source), b.Source.GlobalMerge())
+	if err != nil {
+		return nil, nil, err
+	}
+	if vars, prov, err = b.Overrides.merge(vars, prov); err != nil {
+		return nil, nil, err
+	}
+	vars = vars.StripMarkers()
+	refs := loneReferences(vars)
+	if vars, err = vars.Interpolate(); err != nil {
+		return nil, nil, err
+	}
+
+	// only what is left once markers are gone
+	explained := make(Provenance)
+	for _, leaf := range vars.Leaves() {
+		explained[leaf] = referencedOrigins(leaf, prov, refs)
+	}
+	return vars, explained, nil
+}
+
+// loneReferences returns the dotted key path of every leaf of vars that is
+// nothing but a ${name} reference, mapped to the key path it references.
+func loneReferences(vars VariableMap) map[string]string {
+	refs := make(map[string]string)
+	walkLeaves(reflect.ValueOf(vars), nil, func(keys []string, v reflect.Value) {
+		s, is := v.Interface().(string)
+		if !is {
+			return
+		}
+		m := reference.FindStringSubmatch(s)
+		if m == nil || len(m[0]) != len(s) {
+			return
+		}
+		if to, _, found := find(map[string]interface{}(vars), strings.Split(m[1], "."), nil); found {
+			refs[strings.Join(keys, ".")] = strings.Join(to, ".")
+		}
+	})
+	return refs
+}
+
+// referencedOrigins returns the origins of leaf, following the references
+// of the tables it was copied from.
+func referencedOrigins(leaf string, prov Provenance, refs map[string]string) []Origin {
+	for from, to := range refs {
+		if leaf != from && pathUnder(leaf, from) {
+			return referencedOrigins(to+leaf[len(from):], prov, refs)
+		}
+	}
+	return prov[leaf]
+}
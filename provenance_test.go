@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestOriginString(t *testing.T) {
+	for _, test := range []struct {
+		origin   Origin
+		expected string
+	}{
+		{Origin{Kind: OriginGlobal}, "globals"},
+		{Origin{Kind: OriginGlobal, File: "yate.toml", Line: 3, Col: 1}, "globals at yate.toml:3:1"},
+		{Origin{Kind: OriginResource, Name: "index", File: "yate.toml"}, `resource "index" in yate.toml`},
+		{Origin{Kind: OriginEnv, Name: "YATE_VAR_nav__home"}, "environment variable YATE_VAR_nav__home"},
+		{Origin{Kind: OriginFlag, Name: "nav.home"}, "flag --var nav.home"},
+	} {
+		if s := test.origin.String(); s != test.expected {
+			t.Errorf("incorrect string - expected %q, got %q", test.expected, s)
+		}
+	}
+}
+
+func TestVariableMapLeaves(t *testing.T) {
+	v := VariableMap{
+		"title": "t",
+		"empty": map[string]interface{}{},
+		"nav":   map[string]interface{}{"links": []interface{}{"/"}, "deep": map[string]interface{}{"x": 1}},
+	}
+	expected := []string{"empty", "nav.deep.x", "nav.links", "title"}
+	if leaves := v.Leaves(); !reflect.DeepEqual(leaves, expected) {
+		t.Errorf("incorrect leaves - expected %v, got %v", expected, leaves)
+	}
+}
+
+func TestMergeTracked(t *testing.T) {
+	child := Origin{Kind: OriginResource, Name: "child"}
+	parent := Origin{Kind: OriginResource, Name: "parent"}
+
+	for _, test := range []struct {
+		name     string
+		opts     MergeOptions
+		child    VariableMap
+		parent   VariableMap
+		expected Provenance
+	}{
+		{
+			name:   "child wins",
+			child:  VariableMap{"title": "c", "nav": map[string]interface{}{"home": "/c"}},
+			parent: VariableMap{"title": "p", "footer": "p", "nav": map[string]interface{}{"home": "/p", "about": "/about"}},
+			expected: Provenance{
+				"title":     {child},
+				"footer":    {parent},
+				"nav.home":  {child},
+				"nav.about": {parent},
+			},
+		},
+		{
+			name:   "parent wins",
+			opts:   MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+			child:  VariableMap{"title": "c", "nav": map[string]interface{}{"home": "/c"}},
+			parent: VariableMap{"title": "p", "nav": map[string]interface{}{"about": "/about"}},
+			expected: Provenance{
+				"title":     {parent},
+				"nav.home":  {child},
+				"nav.about": {parent},
+			},
+		},
+		{
+			name:   "parent map replaces scalar",
+			opts:   MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins, Maps: MapsReplace}},
+			child:  VariableMap{"nav": map[string]interface{}{"home": "/c"}},
+			parent: VariableMap{"nav": map[string]interface{}{"about": "/about"}},
+			expected: Provenance{
+				"nav.about": {parent},
+			},
+		},
+		{
+			name:     "append",
+			opts:     MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+			child:    VariableMap{"tags": []interface{}{"c"}},
+			parent:   VariableMap{"tags": []interface{}{"p"}},
+			expected: Provenance{"tags": {parent, child}},
+		},
+		{
+			name:     "prepend",
+			opts:     MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsPrepend}},
+			child:    VariableMap{"tags": []interface{}{"c"}},
+			parent:   VariableMap{"tags": []interface{}{"p"}},
+			expected: Provenance{"tags": {child, parent}},
+		},
+	} {
+		prov := leafOrigins(test.child, child)
+		if _, err := test.child.MergeTracked(prov, test.parent, leafOrigins(test.parent, parent), test.opts); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(prov, test.expected) {
+			t.Errorf("%s: incorrect provenance - expected %v, got %v", test.name, test.expected, prov)
+		}
+	}
+}
+
+func TestResolveProvenance(t *testing.T) {
+	src := inheritanceSource{
+		"base":  {Variables: VariableMap{"title": "base", "footer": "base"}},
+		"mid":   {Inherits: []string{"base"}, Variables: VariableMap{"title": "mid"}},
+		"index": {Inherits: []string{"mid"}, Variables: VariableMap{"body": "index"}},
+	}
+
+	_, prov, err := NewInheritanceResolver(src).ResolveProvenance("index")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := Provenance{
+		"body":   {{Kind: OriginResource, Name: "index"}},
+		"title":  {{Kind: OriginResource, Name: "mid"}},
+		"footer": {{Kind: OriginResource, Name: "base"}},
+	}
+	if !reflect.DeepEqual(prov, expected) {
+		t.Errorf("incorrect provenance - expected %v, got %v", expected, prov)
+	}
+}
+
+func TestBuilderExplain(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"domain": "example.com", "footer": "global", "tags": []interface{}{"global"}},
+		merge:   MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+		resources: map[string]ResourceConfig{
+			"a": {Variables: VariableMap{"title": "a", "tags": []interface{}{"a"}}},
+			"b": {Inherits: []string{"a"}, Variables: VariableMap{"footer": UnsetMarker}},
+		},
+	}
+
+	vars, prov, err := NewBuilder(src, staticResolver{}, nil).Explain("b")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedVars := VariableMap{"domain": "example.com", "title": "a", "tags": []interface{}{"global", "a"}}
+	if !reflect.DeepEqual(vars, expectedVars) {
+		t.Errorf("incorrect variables - expected %v, got %v", expectedVars, vars)
+	}
+	global, a := Origin{Kind: OriginGlobal}, Origin{Kind: OriginResource, Name: "a"}
+	expected := Provenance{
+		"domain": {global},
+		"title":  {a},
+		"tags":   {global, a},
+	}
+	if !reflect.DeepEqual(prov, expected) {
+		t.Errorf("incorrect provenance - expected %v, got %v", expected, prov)
+	}
+}
+
+func TestBuilderExplainReferences(t *testing.T) {
+	src := &mapConfigSource{
+		globals: VariableMap{"site": map[string]interface{}{"host": "example.com", "nav": map[string]interface{}{"home": "/"}}},
+		resources: map[string]ResourceConfig{
+			"a": {Variables: VariableMap{
+				"copy":   "${site}",
+				"again":  "${copy}",
+				"host":   "${site.host}",
+				"nested": map[string]interface{}{"nav": "${site.nav}"},
+			}},
+		},
+	}
+
+	_, prov, err := NewBuilder(src, staticResolver{}, nil).Explain("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	global, a := Origin{Kind: OriginGlobal}, Origin{Kind: OriginResource, Name: "a"}
+	expected := Provenance{
+		"site.host":       {global},
+		"site.nav.home":   {global},
+		"copy.host":       {global},
+		"copy.nav.home":   {global},
+		"again.host":      {global},
+		"again.nav.home":  {global},
+		"host":            {a},
+		"nested.nav.home": {global},
+	}
+	if !reflect.DeepEqual(prov, expected) {
+		t.Errorf("incorrect provenance - expected %v, got %v", expected, prov)
+	}
+}
+
+func TestTomlConfigSourceProvenance(t *testing.T) {
+	src, err := ParseTomlConfigSource("yate.toml", []byte(`
+[globals]
+domain = "example.com"
+
+[resources.base.variables]
+title = "Base"
+"nav.home" = "/"
+
+[resources.index]
+inherits = ["base"]
+
+[resources.index.variables]
+footer = "Index"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	vars, prov, err := NewBuilder(src, staticResolver{}, nil).Explain("index")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(vars) != 4 {
+		t.Errorf("expected 4 variables, got %v", vars)
+	}
+	expected := Provenance{
+		"domain":   {{Kind: OriginGlobal, File: "yate.toml", Line: 3, Col: 1}},
+		"title":    {{Kind: OriginResource, Name: "base", File: "yate.toml", Line: 6, Col: 1}},
+		"nav.home": {{Kind: OriginResource, Name: "base", File: "yate.toml", Line: 7, Col: 1}},
+		"footer":   {{Kind: OriginResource, Name: "index", File: "yate.toml", Line: 13, Col: 1}},
+	}
+	if !reflect.DeepEqual(prov, expected) {
+		t.Errorf("incorrect provenance - expected %v, got %v", expected, prov)
+	}
+}
+
+func TestExplainVariables(t *testing.T) {
+	vars := VariableMap{"title": "Home", "nav": map[string]interface{}{"links": []interface{}{"/"}}}
+	prov := Provenance{
+		"title":     {{Kind: OriginGlobal}},
+		"nav.links": {{Kind: OriginGlobal}, {Kind: OriginResource, Name: "index"}},
+	}
+
+	b := new(bytes.Buffer)
+	if err := explainVariables(b, vars, prov); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "nav.links = [\"/\"]  # globals, resource \"index\"\ntitle = \"Home\"  # globals\n"
+	if b.String() != expected {
+		t.Errorf("incorrect output - expected %q, got %q", expected, b.String())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/parallelblock/yate/cmd"
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/cobra"
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show [resource...]",
+	Short: "Print the effective config of resources",
+	Long: `Show prints the config each resource ends up with once its Inherits chain
+is resolved and globals are filled in, as TOML. With no arguments every
+resource is shown.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		b, err := projectBuilder()
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			args = b.Source.Resources()
+		}
+
+		resources := make(map[string]interface{})
+		for _, r := range args {
+			cfg, err := b.Config(r)
+			if err != nil {
+				return err
+			}
+			vars, err := b.Variables(cfg)
+			if err != nil {
+				return err
+			}
+			resources[r] = effectiveConfigMap(cfg, vars)
+		}
+
+		tree, err := toml.TreeFromMap(map[string]interface{}{"resources": resources})
+		if err != nil {
+			return err
+		}
+		fmt.Print(tree.String())
+		return nil
+	},
+}
+
+func init() {
+	addVarFlag(showCmd)
+	cmd.AddCommand(showCmd)
+}
+
+func effectiveConfigMap(cfg ResourceConfig, vars VariableMap) map[string]interface{} {
+	m := map[string]interface{}{
+		"variables": map[string]interface{}(vars),
+	}
+	if cfg.Template != "" {
+		m["template"] = cfg.Template
+	}
+	if cfg.Output != "" {
+		m["output"] = cfg.Output
+	}
+	if cfg.Escape != "" {
+		m["escape"] = string(cfg.Escape)
+	}
+	if cfg.Delims != (Delimiters{}) {
+		m["delims"] = []string{cfg.Delims.Left, cfg.Delims.Right}
+	}
+	if len(cfg.Inherits) > 0 {
+		m["inherits"] = cfg.Inherits
+	}
+	return m
+}
@@ -1,67 +1,338 @@
 package main
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 )
 
 type VariableMap map[string]interface{}
 
+const (
+	// UnsetMarker is a value that removes its key from the variables a
+	// resource ends up with, whatever its parents have under the key.
+	UnsetMarker = "!unset"
+	// ReplaceMarker is a key that, set to true in a map, makes the map replace
+	// what its parents have under its key rather than be merged with it.
+	ReplaceMarker = "!replace"
+)
+
+// MergeConflictError is a value that couldn't be merged as the types of the
+// child and the parent don't fit together - maps with conflicting key types,
+// or a parent value that the map of the child can't hold. The child keeps
+// its own value.
+type MergeConflictError struct {
+	Path          string
+	Child, Parent reflect.Type
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("cannot merge %s into %s at %q", e.Parent, e.Child, e.Path)
+}
+
+// MergeConflictsError is every conflict found in a merge.
+type MergeConflictsError []*MergeConflictError
+
+func (e MergeConflictsError) Error() string {
+	msgs := make([]string, len(e))
+	for i, c := range e {
+		msgs[i] = c.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ConflictPolicy decides which side keeps a key that both sides of a merge
+// set, when their values aren't merged together.
+type ConflictPolicy string
+
+const (
+	// ChildWins keeps the value of the map being merged into.
+	ChildWins ConflictPolicy = "child"
+	// ParentWins takes the value of the map being merged from.
+	ParentWins ConflictPolicy = "parent"
+)
+
+// MapPolicy decides how two maps under the same key are merged.
+type MapPolicy string
+
+const (
+	// MapsDeep merges maps key by key.
+	MapsDeep MapPolicy = "deep"
+	// MapsReplace treats maps as single values.
+	MapsReplace MapPolicy = "replace"
+)
+
+// ListPolicy decides how two lists under the same key are merged.
+type ListPolicy string
+
+const (
+	// ListsReplace treats lists as single values.
+	ListsReplace ListPolicy = "replace"
+	// ListsAppend puts the items of the child after those of the parent.
+	ListsAppend ListPolicy = "append"
+	// ListsPrepend puts the items of the child before those of the parent.
+	ListsPrepend ListPolicy = "prepend"
+	// ListsUnion appends, leaving out items already in the list.
+	ListsUnion ListPolicy = "union"
+)
+
+// MergeStrategy is how one variable map is merged into another. Empty fields
+// fall back to the enclosing strategy, and in the end to ChildWins, MapsDeep
+// and ListsReplace, which is how MergeFrom merges.
+type MergeStrategy struct {
+	Conflicts ConflictPolicy
+	Maps      MapPolicy
+	Lists     ListPolicy
+}
+
+// Or returns s with its empty fields taken from fallback.
+func (s MergeStrategy) Or(fallback MergeStrategy) MergeStrategy {
+	if s.Conflicts == "" {
+		s.Conflicts = fallback.Conflicts
+	}
+	if s.Maps == "" {
+		s.Maps = fallback.Maps
+	}
+	if s.Lists == "" {
+		s.Lists = fallback.Lists
+	}
+	return s
+}
+
+var defaultMergeStrategy = MergeStrategy{ChildWins, MapsDeep, ListsReplace}
+
+// MergeOptions is a merge strategy along with the strategies of particular
+// values, keyed by their dotted key path. A path's strategy also applies to
+// everything beneath it that doesn't have one of its own.
+type MergeOptions struct {
+	MergeStrategy
+	Paths map[string]MergeStrategy
+}
+
+func (o MergeOptions) at(path string, enclosing MergeStrategy) MergeStrategy {
+	return o.Paths[path].Or(enclosing)
+}
+
+func joinKeyPath(path string, key reflect.Value) string {
+	if path == "" {
+		return fmt.Sprint(key.Interface())
+	}
+	return path + "." + fmt.Sprint(key.Interface())
+}
+
 // this function assumes to and from are both maps
 // checks to ensure that key/values from from can fit into to
 func mapsCompatible(to, from reflect.Value) bool {
 	return from.Type().Key().AssignableTo(to.Type().Key())
 }
 
-const mergeBadKeysPanic = "cannot merge conflicting map key types"
-const mergeBadValsPanic = "cannot merge conflicting map value types"
+// unwraps the value stored in a map, which is an interface for maps of
+// interface{}, into the value it holds
+func elem(v reflect.Value) reflect.Value {
+	if v.IsValid() && v.Kind() == reflect.Interface {
+		return v.Elem()
+	}
+	return v
+}
+
+func kindIs(v reflect.Value, k reflect.Kind) bool {
+	return v.IsValid() && v.Kind() == k
+}
+
+// merger merges maps by a set of options, collecting conflicts as it goes
+// rather than stopping at the first. If prov is set, it is the provenance of
+// the child and is kept up to date with the origins of what it takes from
+// the parent, whose provenance is parentProv.
+type merger struct {
+	opts       MergeOptions
+	conflicts  MergeConflictsError
+	prov       Provenance
+	parentProv Provenance
+}
+
+// setMapIndex stores v in m under k, or records a conflict at path with the
+// type of the parent's value if m can't hold it.
+func (mg *merger) setMapIndex(m, k, v reflect.Value, path string, parent reflect.Value) bool {
+	if !v.IsValid() {
+		v = reflect.Zero(m.Type().Elem())
+	} else if !v.Type().AssignableTo(m.Type().Elem()) {
+		mg.conflicts = append(mg.conflicts, &MergeConflictError{path, m.Type().Elem(), parent.Type()})
+		return false
+	}
+	m.SetMapIndex(k, v)
+	return true
+}
 
-func mergeReflectingMaps(to, from reflect.Value) {
+func (mg *merger) mergeReflectingMaps(to, from reflect.Value, path string, s MergeStrategy) {
 	if !mapsCompatible(to, from) {
-		panic(mergeBadKeysPanic)
+		mg.conflicts = append(mg.conflicts, &MergeConflictError{path, to.Type(), from.Type()})
+		return
 	}
 
 	for _, k := range from.MapKeys() {
+		keyPath := joinKeyPath(path, k)
+		fromElem := elem(from.MapIndex(k))
 		toVal := to.MapIndex(k)
 		if !toVal.IsValid() {
-			fromVal := from.MapIndex(k)
-			if !fromVal.Type().AssignableTo(to.Type().Elem()) {
-				panic(mergeBadValsPanic)
+			if mg.setMapIndex(to, k, fromElem, keyPath, fromElem) && mg.prov != nil {
+				mg.prov.take(keyPath, mg.parentProv)
 			}
-
-			to.SetMapIndex(k, fromVal)
 			continue
 		}
 
-		toValReeval := reflect.ValueOf(toVal.Interface())
-		if toValReeval.Type().Kind() == reflect.Map {
-			fromVal := from.MapIndex(k)
-			fromValReeval := reflect.ValueOf(fromVal.Interface())
-			if fromValReeval.Type().Kind() == reflect.Map {
-				// we must go deeper!
-				mergeReflectingMaps(toValReeval, fromValReeval)
+		ks := mg.opts.at(keyPath, s)
+		toElem := elem(toVal)
+		switch {
+		case isUnset(toElem) || replaces(toElem):
+			// markers always win, and are kept so they are inherited in turn
+		case ks.Maps == MapsDeep && kindIs(toElem, reflect.Map) && kindIs(fromElem, reflect.Map):
+			// we must go deeper!
+			mg.mergeReflectingMaps(toElem, fromElem, keyPath, ks)
+		case ks.Lists != ListsReplace && kindIs(toElem, reflect.Slice) && kindIs(fromElem, reflect.Slice):
+			if mg.setMapIndex(to, k, mergeLists(toElem, fromElem, ks.Lists), keyPath, fromElem) && mg.prov != nil {
+				mg.prov.mergeList(keyPath, mg.parentProv, ks.Lists)
+			}
+		case ks.Conflicts == ParentWins:
+			if mg.setMapIndex(to, k, fromElem, keyPath, fromElem) && mg.prov != nil {
+				mg.prov.take(keyPath, mg.parentProv)
 			}
 		}
 	}
 }
 
-func (v VariableMap) MergeFrom(v2 VariableMap) VariableMap {
-	for k, newVal := range v2 {
-		existingVal, h := v[k]
-		if !h {
-			v[k] = newVal
-			// quick exit before we try to do reflection
-			continue
+func isUnset(v reflect.Value) bool {
+	return kindIs(v, reflect.String) && v.String() == UnsetMarker
+}
+
+// replaces reports whether v is a map marked to replace what it is merged with
+func replaces(v reflect.Value) bool {
+	if !kindIs(v, reflect.Map) || v.Type().Key().Kind() != reflect.String {
+		return false
+	}
+	marker := elem(v.MapIndex(reflect.ValueOf(ReplaceMarker).Convert(v.Type().Key())))
+	return kindIs(marker, reflect.Bool) && marker.Bool()
+}
+
+func stripMarkers(m reflect.Value) {
+	isStringKeyed := m.Type().Key().Kind() == reflect.String
+	for _, k := range m.MapKeys() {
+		v := elem(m.MapIndex(k))
+		if isUnset(v) || (isStringKeyed && k.String() == ReplaceMarker) {
+			m.SetMapIndex(k, reflect.Value{})
+		} else if kindIs(v, reflect.Map) {
+			stripMarkers(v)
 		}
+	}
+}
 
-		// special case where both are maps - we will try to merge lower!
-		reflNewVal := reflect.ValueOf(newVal)
-		if reflNewVal.Type().Kind() == reflect.Map {
-			// this is a map
-			reflExistingVal := reflect.ValueOf(existingVal)
-			if reflExistingVal.Type().Kind() == reflect.Map {
-				mergeReflectingMaps(reflExistingVal, reflNewVal)
+// StripMarkers removes unset keys and replace markers from v and the maps
+// nested in it, once it has been merged with everything it inherits.
+func (v VariableMap) StripMarkers() VariableMap {
+	stripMarkers(reflect.ValueOf(v))
+	return v
+}
+
+// mergeLists combines the lists of a child and a parent into a new list,
+// which is of the type of both if they match, otherwise a []interface{}.
+func mergeLists(child, parent reflect.Value, policy ListPolicy) reflect.Value {
+	first, second := parent, child
+	if policy == ListsPrepend {
+		first, second = child, parent
+	}
+
+	t := reflect.TypeOf([]interface{}{})
+	if child.Type() == parent.Type() {
+		t = child.Type()
+	}
+	merged := reflect.MakeSlice(t, 0, first.Len()+second.Len())
+	for _, l := range []reflect.Value{first, second} {
+		for i := 0; i < l.Len(); i++ {
+			item := l.Index(i)
+			if policy == ListsUnion && listHas(merged, item) {
+				continue
 			}
+			merged = reflect.Append(merged, item)
 		}
 	}
-	return v
+	return merged
+}
+
+func listHas(l, item reflect.Value) bool {
+	for i := 0; i < l.Len(); i++ {
+		if reflect.DeepEqual(l.Index(i).Interface(), item.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeFrom fills in the keys of v that v2 has and v doesn't, merging maps
+// that both have key by key. Everything else v already has is kept.
+func (v VariableMap) MergeFrom(v2 VariableMap) (VariableMap, error) {
+	return v.MergeWith(v2, MergeOptions{})
+}
+
+// MergeWith merges v2 into v by opts, where v is the child and v2 the parent.
+// As with MergeFrom, nested maps of v are merged into in place and values of
+// v2 are taken as they are, so both should be copies if the originals are to
+// be kept as they are. Merged lists are always new lists.
+//
+// Values that conflict are left out and the rest of v2 is still merged, with
+// every conflict returned together as a MergeConflictsError.
+func (v VariableMap) MergeWith(v2 VariableMap, opts MergeOptions) (VariableMap, error) {
+	return v.mergeTracked(nil, v2, nil, opts)
+}
+
+// MergeTracked merges like MergeWith, also merging prov2, the provenance of
+// v2, into prov, the provenance of v.
+func (v VariableMap) MergeTracked(prov Provenance, v2 VariableMap, prov2 Provenance, opts MergeOptions) (VariableMap, error) {
+	if prov == nil {
+		prov = Provenance{}
+	}
+	return v.mergeTracked(prov, v2, prov2, opts)
+}
+
+func (v VariableMap) mergeTracked(prov Provenance, v2 VariableMap, prov2 Provenance, opts MergeOptions) (VariableMap, error) {
+	mg := &merger{opts: opts, prov: prov, parentProv: prov2}
+	mg.mergeReflectingMaps(reflect.ValueOf(v), reflect.ValueOf(v2), "", opts.MergeStrategy.Or(defaultMergeStrategy))
+	if len(mg.conflicts) > 0 {
+		sort.Slice(mg.conflicts, func(i, j int) bool {
+			return mg.conflicts[i].Path < mg.conflicts[j].Path
+		})
+		return v, mg.conflicts
+	}
+	return v, nil
+}
+
+// copies a single value, duplicating it if it happens to be a map so that
+// later merges into the copy never write through to the original
+func copyValue(val interface{}) interface{} {
+	if m, is := val.(VariableMap); is {
+		return m.Copy()
+	}
+
+	refl := reflect.ValueOf(val)
+	if !refl.IsValid() || refl.Kind() != reflect.Map {
+		return val
+	}
+
+	dup := reflect.MakeMap(refl.Type())
+	for _, k := range refl.MapKeys() {
+		elem := reflect.ValueOf(copyValue(refl.MapIndex(k).Interface()))
+		if !elem.IsValid() {
+			elem = reflect.Zero(refl.Type().Elem())
+		}
+		dup.SetMapIndex(k, elem)
+	}
+	return dup.Interface()
+}
+
+// Copy returns a deep copy of the map, including any nested maps.
+func (v VariableMap) Copy() VariableMap {
+	c := make(VariableMap, len(v))
+	for k, val := range v {
+		c[k] = copyValue(val)
+	}
+	return c
 }
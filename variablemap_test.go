@@ -21,7 +21,198 @@ var variableMapMergeTests = []struct {
 func TestVariableMapMerge(t *testing.T) {
 	for i, tt := range variableMapMergeTests {
 		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
-			result := tt.object.MergeFrom(tt.argument)
+			result, err := tt.object.MergeFrom(tt.argument)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(result, tt.result) {
+				t.Errorf("unequal results - expected %v, got %v", tt.result, result)
+			}
+		})
+	}
+}
+
+var variableMapMergeWithTests = []struct {
+	name                     string
+	opts                     MergeOptions
+	object, argument, result VariableMap
+}{
+	{"default is merge from", MergeOptions{},
+		VariableMap{"a": "child", "l": []interface{}{"child"}, "m": map[string]interface{}{"x": "child"}},
+		VariableMap{"a": "parent", "b": "parent", "l": []interface{}{"parent"}, "m": map[string]interface{}{"x": "parent", "y": "parent"}},
+		VariableMap{"a": "child", "b": "parent", "l": []interface{}{"child"}, "m": map[string]interface{}{"x": "child", "y": "parent"}}},
+	{"parent wins", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": "child", "c": "child", "m": map[string]interface{}{"x": "child", "z": "child"}},
+		VariableMap{"a": "parent", "m": map[string]interface{}{"x": "parent"}},
+		VariableMap{"a": "parent", "c": "child", "m": map[string]interface{}{"x": "parent", "z": "child"}}},
+	{"parent wins over other kinds", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": "child", "b": map[string]interface{}{"x": "child"}, "n": "child"},
+		VariableMap{"a": map[string]interface{}{"x": "parent"}, "b": "parent", "n": nil},
+		VariableMap{"a": map[string]interface{}{"x": "parent"}, "b": "parent", "n": nil}},
+	{"child keeps nil", MergeOptions{},
+		VariableMap{"n": nil},
+		VariableMap{"n": "parent"},
+		VariableMap{"n": nil}},
+	{"maps replaced", MergeOptions{MergeStrategy: MergeStrategy{Maps: MapsReplace}},
+		VariableMap{"m": map[string]interface{}{"x": "child"}},
+		VariableMap{"m": map[string]interface{}{"y": "parent"}},
+		VariableMap{"m": map[string]interface{}{"x": "child"}}},
+	{"maps replaced by parent", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins, Maps: MapsReplace}},
+		VariableMap{"m": map[string]interface{}{"x": "child"}},
+		VariableMap{"m": map[string]interface{}{"y": "parent"}},
+		VariableMap{"m": map[string]interface{}{"y": "parent"}}},
+	{"lists replaced by parent", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"l": []interface{}{"child"}},
+		VariableMap{"l": []interface{}{"parent"}},
+		VariableMap{"l": []interface{}{"parent"}}},
+	{"lists appended", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+		VariableMap{"l": []interface{}{"a", "b"}},
+		VariableMap{"l": []interface{}{"b", "c"}},
+		VariableMap{"l": []interface{}{"b", "c", "a", "b"}}},
+	{"lists prepended", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsPrepend}},
+		VariableMap{"l": []interface{}{"a", "b"}},
+		VariableMap{"l": []interface{}{"b", "c"}},
+		VariableMap{"l": []interface{}{"a", "b", "b", "c"}}},
+	{"lists unioned", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsUnion}},
+		VariableMap{"l": []interface{}{"a", "b", "a", map[string]interface{}{"k": "v"}}},
+		VariableMap{"l": []interface{}{"b", "c", map[string]interface{}{"k": "v"}}},
+		VariableMap{"l": []interface{}{"b", "c", map[string]interface{}{"k": "v"}, "a"}}},
+	{"list and scalar", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+		VariableMap{"l": []interface{}{"a"}, "s": "child"},
+		VariableMap{"l": "parent", "s": []interface{}{"b"}},
+		VariableMap{"l": []interface{}{"a"}, "s": "child"}},
+	{"typed lists appended", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+		VariableMap{"l": []string{"a"}, "m": []int64{1}},
+		VariableMap{"l": []string{"b"}, "m": []interface{}{int64(2)}},
+		VariableMap{"l": []string{"b", "a"}, "m": []interface{}{int64(2), int64(1)}}},
+	{"typed maps", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins, Lists: ListsAppend}},
+		VariableMap{"m": map[string]string{"x": "child", "z": "child"}, "l": map[string][]string{"x": {"a"}}},
+		VariableMap{"m": map[string]string{"x": "parent", "y": "parent"}, "l": map[string][]string{"x": {"b"}, "y": {"c"}}},
+		VariableMap{"m": map[string]string{"x": "parent", "y": "parent", "z": "child"}, "l": map[string][]string{"x": {"b", "a"}, "y": {"c"}}}},
+	{"typed maps in interface maps", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": map[string]interface{}{"b": map[string]string{"x": "child", "z": "child"}}},
+		VariableMap{"a": map[string]interface{}{"b": map[string]string{"x": "parent"}}},
+		VariableMap{"a": map[string]interface{}{"b": map[string]string{"x": "parent", "z": "child"}}}},
+	{"paths", MergeOptions{
+		MergeStrategy: MergeStrategy{Conflicts: ParentWins},
+		Paths: map[string]MergeStrategy{
+			"nav":         {Lists: ListsUnion},
+			"nav.title":   {Conflicts: ChildWins},
+			"owner":       {Maps: MapsReplace, Conflicts: ChildWins},
+			"tags":        {Lists: ListsPrepend},
+			"nav.missing": {Lists: ListsAppend},
+		}},
+		VariableMap{
+			"nav":   map[string]interface{}{"title": "child", "links": []interface{}{"a", "b"}, "sub": map[string]interface{}{"l": []interface{}{"x"}}},
+			"owner": map[string]interface{}{"name": "child"},
+			"tags":  []interface{}{"a"},
+			"other": []interface{}{"a"},
+		},
+		VariableMap{
+			"nav":   map[string]interface{}{"title": "parent", "links": []interface{}{"b", "c"}, "sub": map[string]interface{}{"l": []interface{}{"x", "y"}}},
+			"owner": map[string]interface{}{"email": "parent"},
+			"tags":  []interface{}{"b"},
+			"other": []interface{}{"b"},
+		},
+		VariableMap{
+			"nav":   map[string]interface{}{"title": "child", "links": []interface{}{"b", "c", "a"}, "sub": map[string]interface{}{"l": []interface{}{"x", "y"}}},
+			"owner": map[string]interface{}{"name": "child"},
+			"tags":  []interface{}{"a", "b"},
+			"other": []interface{}{"b"},
+		}},
+	{"non-string keys", MergeOptions{Paths: map[string]MergeStrategy{"m.1": {Conflicts: ParentWins}}},
+		VariableMap{"m": map[int]string{1: "child", 2: "child"}},
+		VariableMap{"m": map[int]string{1: "parent", 2: "parent"}},
+		VariableMap{"m": map[int]string{1: "parent", 2: "child"}}},
+}
+
+func TestVariableMapMergeWith(t *testing.T) {
+	for _, tt := range variableMapMergeWithTests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.object.Copy().MergeWith(tt.argument.Copy(), tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(result, tt.result) {
+				t.Errorf("unequal results - expected %v, got %v", tt.result, result)
+			}
+		})
+	}
+}
+
+func TestVariableMapMergeWithNewLists(t *testing.T) {
+	child := make([]interface{}, 1, 4)
+	child[0] = "a"
+	parent := []interface{}{"b"}
+
+	a := VariableMap{"l": child}
+	a.MergeWith(VariableMap{"l": parent}, MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsPrepend}})
+	a["l"].([]interface{})[0] = "changed"
+
+	if child[:2][1] != nil || child[0] != "a" || parent[0] != "b" {
+		t.Errorf("merging lists wrote through to the originals: %v and %v", child[:2], parent)
+	}
+}
+
+func TestMergeStrategyOr(t *testing.T) {
+	s := MergeStrategy{Lists: ListsUnion}.Or(MergeStrategy{ParentWins, MapsReplace, ListsAppend})
+	expected := MergeStrategy{ParentWins, MapsReplace, ListsUnion}
+	if s != expected {
+		t.Errorf("incorrect strategy - expected %v, got %v", expected, s)
+	}
+}
+
+var (
+	stringType      = reflect.TypeOf("")
+	intType         = reflect.TypeOf(0)
+	stringMapType   = reflect.TypeOf(map[string]string{})
+	stringListsType = reflect.TypeOf(map[string][]string{})
+)
+
+var variableMapMergeConflictTests = []struct {
+	name                     string
+	opts                     MergeOptions
+	object, argument, result VariableMap
+	conflicts                MergeConflictsError
+}{
+	{"keys", MergeOptions{},
+		VariableMap{"a": map[string]string{"a": "a"}},
+		VariableMap{"a": map[int]string{3: "maybe"}},
+		VariableMap{"a": map[string]string{"a": "a"}},
+		MergeConflictsError{{"a", stringMapType, reflect.TypeOf(map[int]string{})}}},
+	{"values", MergeOptions{},
+		VariableMap{"a": map[string]string{"a": "a"}},
+		VariableMap{"a": map[string]int{"b": 3}},
+		VariableMap{"a": map[string]string{"a": "a"}},
+		MergeConflictsError{{"a.b", stringType, intType}}},
+	{"overridden values", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": map[string]string{"a": "a"}},
+		VariableMap{"a": map[string]interface{}{"a": 1, "b": "b"}},
+		VariableMap{"a": map[string]string{"a": "a", "b": "b"}},
+		MergeConflictsError{{"a.a", stringType, intType}}},
+	{"list values", MergeOptions{MergeStrategy: MergeStrategy{Lists: ListsAppend}},
+		VariableMap{"a": map[string][]string{"a": {"a"}}},
+		VariableMap{"a": map[string][]int{"a": {1}}},
+		VariableMap{"a": map[string][]string{"a": {"a"}}},
+		MergeConflictsError{{"a.a", reflect.TypeOf([]string{}), reflect.TypeOf([]int{})}}},
+	{"every conflict", MergeOptions{},
+		VariableMap{"a": map[string]string{}, "b": map[string]interface{}{"c": map[string][]string{}}, "d": "d"},
+		VariableMap{"a": map[string]interface{}{"x": 1, "y": "y", "z": true}, "b": map[string]interface{}{"c": map[string]int{"n": 1}}, "e": "e"},
+		VariableMap{"a": map[string]string{"y": "y"}, "b": map[string]interface{}{"c": map[string][]string{}}, "d": "d", "e": "e"},
+		MergeConflictsError{
+			{"a.x", stringType, intType},
+			{"a.z", stringType, reflect.TypeOf(true)},
+			{"b.c.n", reflect.TypeOf([]string{}), intType},
+		}},
+}
+
+func TestVariableMapMergeConflicts(t *testing.T) {
+	for _, tt := range variableMapMergeConflictTests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.object.MergeWith(tt.argument, tt.opts)
+			if !reflect.DeepEqual(err, tt.conflicts) {
+				t.Errorf("incorrect conflicts - expected %v, got %v", tt.conflicts, err)
+			}
 			if !reflect.DeepEqual(result, tt.result) {
 				t.Errorf("unequal results - expected %v, got %v", tt.result, result)
 			}
@@ -29,32 +220,104 @@ func TestVariableMapMerge(t *testing.T) {
 	}
 }
 
-func TestOddballMapMergeKeys(t *testing.T) {
-	defer func() {
-		r := recover()
-		if r != mergeBadKeysPanic {
-			t.Errorf("failed with incorrect panic - expected %s got %s", mergeBadKeysPanic, r)
-		}
-	}()
+func TestMergeConflictsError(t *testing.T) {
+	err := MergeConflictsError{{"a.b", stringType, intType}, {"c", stringMapType, stringListsType}}
+	expected := `cannot merge int into string at "a.b"; cannot merge map[string][]string into map[string]string at "c"`
+	if err.Error() != expected {
+		t.Errorf("incorrect message - expected %s, got %s", expected, err.Error())
+	}
+}
 
-	a := VariableMap{"a": map[string]string{"a": "a"}}
-	b := VariableMap{"a": map[int]string{3: "maybe"}}
-	a.MergeFrom(b)
+var variableMapMarkerTests = []struct {
+	name                     string
+	opts                     MergeOptions
+	object, argument, result VariableMap
+}{
+	{"unset kept", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": UnsetMarker, "m": map[string]interface{}{"b": UnsetMarker}},
+		VariableMap{"a": "parent", "m": map[string]interface{}{"b": "parent", "c": "parent"}},
+		VariableMap{"a": UnsetMarker, "m": map[string]interface{}{"b": UnsetMarker, "c": "parent"}}},
+	{"unset inherited", MergeOptions{},
+		VariableMap{},
+		VariableMap{"a": UnsetMarker},
+		VariableMap{"a": UnsetMarker}},
+	{"unset by parent", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins}},
+		VariableMap{"a": "child"},
+		VariableMap{"a": UnsetMarker},
+		VariableMap{"a": UnsetMarker}},
+	{"replace", MergeOptions{MergeStrategy: MergeStrategy{Conflicts: ParentWins, Lists: ListsAppend}},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true, "l": []interface{}{"child"}}},
+		VariableMap{"m": map[string]interface{}{"l": []interface{}{"parent"}, "x": "parent"}},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true, "l": []interface{}{"child"}}}},
+	{"replace a value", MergeOptions{},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true}},
+		VariableMap{"m": "parent"},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true}}},
+	{"replace off", MergeOptions{},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: false, "a": "child"}},
+		VariableMap{"m": map[string]interface{}{"b": "parent"}},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: false, "a": "child", "b": "parent"}}},
+	{"replace in parent", MergeOptions{},
+		VariableMap{"m": map[string]interface{}{"a": "child"}},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true, "b": "parent"}},
+		VariableMap{"m": map[string]interface{}{ReplaceMarker: true, "a": "child", "b": "parent"}}},
+}
+
+func TestVariableMapMergeMarkers(t *testing.T) {
+	for _, tt := range variableMapMarkerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.object.Copy().MergeWith(tt.argument.Copy(), tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(result, tt.result) {
+				t.Errorf("unequal results - expected %v, got %v", tt.result, result)
+			}
+		})
+	}
+}
 
-	t.Errorf("did not panic")
+func TestVariableMapStripMarkers(t *testing.T) {
+	v := VariableMap{
+		"a": UnsetMarker,
+		"b": "b",
+		"m": map[string]interface{}{ReplaceMarker: true, "c": UnsetMarker, "d": map[string]string{"e": UnsetMarker, "f": "f"}},
+		"n": map[string]interface{}{ReplaceMarker: false},
+		"i": map[int]string{1: UnsetMarker, 2: "2"},
+		"l": []interface{}{UnsetMarker},
+	}
+	expected := VariableMap{
+		"b": "b",
+		"m": map[string]interface{}{"d": map[string]string{"f": "f"}},
+		"n": map[string]interface{}{},
+		"i": map[int]string{2: "2"},
+		"l": []interface{}{UnsetMarker},
+	}
+	if result := v.StripMarkers(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("incorrect result - expected %v, got %v", expected, result)
+	}
 }
 
-func TestOddballMapMergeValues(t *testing.T) {
-	defer func() {
-		r := recover()
-		if r != mergeBadValsPanic {
-			t.Errorf("failed with incorrect panic - expected %s got %s", mergeBadValsPanic, r)
-		}
-	}()
+func TestVariableMapCopy(t *testing.T) {
+	nested := map[string]string{"a": "value1"}
+	deep := map[string]interface{}{"b": map[string]string{"c": "value2"}, "n": nil}
+	a := VariableMap{"a": nested, "b": deep, "c": "value3", "d": VariableMap{"e": "value4"}}
+
+	c := a.Copy()
+	if !reflect.DeepEqual(a, c) {
+		t.Fatalf("copy differs from original - expected %v, got %v", a, c)
+	}
 
-	a := VariableMap{"a": map[string]string{"a": "a"}}
-	b := VariableMap{"a": map[string]int{"b": 3}}
-	a.MergeFrom(b)
+	c.MergeFrom(VariableMap{"a": map[string]string{"x": "y"}, "b": map[string]interface{}{"b": map[string]string{"x": "y"}}})
+	c["d"].(VariableMap)["x"] = "y"
 
-	t.Errorf("did not panic")
+	if _, h := nested["x"]; h {
+		t.Errorf("merging into copy modified the original nested map")
+	}
+	if _, h := deep["b"].(map[string]string)["x"]; h {
+		t.Errorf("merging into copy modified the original deeply nested map")
+	}
+	if _, h := a["d"].(VariableMap)["x"]; h {
+		t.Errorf("modifying copy modified the original variable map")
+	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/parallelblock/yate/cmd"
+	"github.com/pelletier/go-toml"
+	"github.com/spf13/cobra"
+)
+
+var varsExplain bool
+
+var varsCmd = &cobra.Command{
+	Use:   "vars <resource>",
+	Short: "Print the variables a resource is rendered with",
+	Long: `Vars prints the variables a resource ends up with once its Inherits chain
+is resolved and globals are merged in, as TOML. With --explain each variable
+is printed on its own line along with where it was set.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		b, err := projectBuilder()
+		if err != nil {
+			return err
+		}
+
+		vars, prov, err := b.Explain(args[0])
+		if err != nil {
+			return err
+		}
+
+		if varsExplain {
+			return explainVariables(os.Stdout, vars, prov)
+		}
+		tree, err := toml.TreeFromMap(vars)
+		if err != nil {
+			return err
+		}
+		fmt.Print(tree.String())
+		return nil
+	},
+}
+
+func init() {
+	varsCmd.Flags().BoolVar(&varsExplain, "explain", false, "show where each variable was set")
+	addVarFlag(varsCmd)
+	cmd.AddCommand(varsCmd)
+}
+
+// explainVariables writes each leaf of vars as its key path and JSON value,
+// followed by the origins of the value.
+func explainVariables(w io.Writer, vars VariableMap, prov Provenance) error {
+	values := make(map[string]interface{})
+	walkLeaves(reflect.ValueOf(vars), nil, func(keys []string, v reflect.Value) {
+		values[strings.Join(keys, ".")] = v.Interface()
+	})
+
+	for _, leaf := range vars.Leaves() {
+		b, err := json.Marshal(values[leaf])
+		if err != nil {
+			return err
+		}
+
+		origins := make([]string, len(prov[leaf]))
+		for i, o := range prov[leaf] {
+			origins[i] = o.String()
+		}
+		fmt.Fprintf(w, "%s = %s  # %s\n", leaf, b, strings.Join(origins, ", "))
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"github.com/fsnotify/fsnotify"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -18,6 +22,39 @@ type RawFileWatcher interface {
 	Errors() chan error
 }
 
+// FsnotifyWatcher adapts a *fsnotify.Watcher into a RawFileWatcher.
+type FsnotifyWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func NewFsnotifyWatcher() (*FsnotifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &FsnotifyWatcher{w}, nil
+}
+
+func (f *FsnotifyWatcher) Close() error {
+	return f.w.Close()
+}
+
+func (f *FsnotifyWatcher) Add(path string) error {
+	return f.w.Add(path)
+}
+
+func (f *FsnotifyWatcher) Remove(path string) error {
+	return f.w.Remove(path)
+}
+
+func (f *FsnotifyWatcher) Events() chan fsnotify.Event {
+	return f.w.Events
+}
+
+func (f *FsnotifyWatcher) Errors() chan error {
+	return f.w.Errors
+}
+
 type Action func()
 
 type ActionedFileWatcher interface {
@@ -27,19 +64,298 @@ type ActionedFileWatcher interface {
 
 type TimeDelaySupplier func() <-chan time.Time
 
+// QuietPeriod supplies delays of a fixed duration.
+func QuietPeriod(d time.Duration) TimeDelaySupplier {
+	return func() <-chan time.Time {
+		return time.After(d)
+	}
+}
+
+var ErrWatcherClosed = errors.New("watcher is closed")
+
 type DelayableFileWatchMgrCfg struct {
+	// QuietTime is called on every event to start the window that must pass
+	// without further events before a watcher's action runs.
 	QuietTime TimeDelaySupplier
+	// Errors receives errors from the underlying watcher, if set.
+	Errors func(error)
 }
 
+// DelayableFileWatchMgr multiplexes any number of actioned watchers onto a
+// single RawFileWatcher. Each watcher runs its action once events on its
+// paths have stopped for the quiet time, however many arrived before then.
+//
+// Paths that don't exist are watched through their directory until they are
+// created, so that a file that is missing or has been replaced is still seen.
 type DelayableFileWatchMgr struct {
 	c DelayableFileWatchMgrCfg
 	p RawFileWatcher
+
+	mx       sync.Mutex
+	all      map[*delayedWatcher]struct{}
+	watchers map[string]map[*delayedWatcher]struct{}
+	// how many watched paths each raw watch is for, counting a directory
+	// once for each missing path in it
+	raw     map[string]int
+	missing map[string]struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+func NewDelayableFileWatchMgr(c DelayableFileWatchMgrCfg, p RawFileWatcher) *DelayableFileWatchMgr {
+	d := &DelayableFileWatchMgr{
+		c:        c,
+		p:        p,
+		all:      make(map[*delayedWatcher]struct{}),
+		watchers: make(map[string]map[*delayedWatcher]struct{}),
+		raw:      make(map[string]int),
+		missing:  make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *DelayableFileWatchMgr) run() {
+	for {
+		select {
+		// fsnotify closes its channels when it is closed
+		case e, ok := <-d.p.Events():
+			if !ok {
+				return
+			}
+			d.dispatch(e)
+		case err, ok := <-d.p.Errors():
+			if !ok {
+				return
+			}
+			if d.c.Errors != nil && err != nil {
+				d.c.Errors(err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *DelayableFileWatchMgr) dispatch(e fsnotify.Event) {
+	// permission and timestamp changes don't change what gets rendered
+	if e.Op == fsnotify.Chmod {
+		return
+	}
+
+	path := filepath.Clean(e.Name)
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	watchers, h := d.watchers[path]
+	if h {
+		_, missing := d.missing[path]
+		// editors often save by replacing the file, which drops the
+		// underlying watch - pick the new file up again, or watch for it
+		// to be created
+		if (missing && e.Op&fsnotify.Create != 0) || (!missing && e.Op&(fsnotify.Remove|fsnotify.Rename) != 0) {
+			// the old watch usually goes with the file, so it can't be removed
+			d.unwatch(path)
+			if err := d.watch(path); err != nil && d.c.Errors != nil {
+				d.c.Errors(err)
+			}
+		}
+	}
+	for w := range watchers {
+		w.kick()
+	}
+
+	// files coming and going change what is globbed from a watched directory
+	if e.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+		for w := range d.watchers[filepath.Dir(path)] {
+			w.kick()
+		}
+	}
+}
+
+func (d *DelayableFileWatchMgr) watchRaw(path string) error {
+	if d.raw[path] == 0 {
+		if err := d.p.Add(path); err != nil {
+			return err
+		}
+	}
+	d.raw[path]++
+	return nil
 }
 
+func (d *DelayableFileWatchMgr) unwatchRaw(path string) error {
+	if d.raw[path] == 0 {
+		// watching it again after it was replaced failed
+		return nil
+	}
+	if d.raw[path]--; d.raw[path] > 0 {
+		return nil
+	}
+	delete(d.raw, path)
+	return d.p.Remove(path)
+}
+
+// watch starts the raw watch of a watched path, watching its directory
+// instead while it doesn't exist.
+func (d *DelayableFileWatchMgr) watch(path string) error {
+	err := d.watchRaw(path)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	if derr := d.watchRaw(filepath.Dir(path)); derr != nil {
+		return err
+	}
+	d.missing[path] = struct{}{}
+	return nil
+}
+
+// unwatch stops the raw watch of a watched path.
+func (d *DelayableFileWatchMgr) unwatch(path string) error {
+	if _, h := d.missing[path]; h {
+		delete(d.missing, path)
+		return d.unwatchRaw(filepath.Dir(path))
+	}
+	return d.unwatchRaw(path)
+}
+
+func (d *DelayableFileWatchMgr) add(w *delayedWatcher, path string) error {
+	path = filepath.Clean(path)
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.closed {
+		return ErrWatcherClosed
+	}
+
+	watchers, h := d.watchers[path]
+	if !h {
+		if err := d.watch(path); err != nil {
+			return err
+		}
+		watchers = make(map[*delayedWatcher]struct{})
+		d.watchers[path] = watchers
+	}
+	watchers[w] = struct{}{}
+	return nil
+}
+
+func (d *DelayableFileWatchMgr) remove(w *delayedWatcher) (err error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	delete(d.all, w)
+	for path, watchers := range d.watchers {
+		if _, h := watchers[w]; !h {
+			continue
+		}
+
+		delete(watchers, w)
+		if len(watchers) == 0 {
+			delete(d.watchers, path)
+			if rerr := d.unwatch(path); err == nil {
+				err = rerr
+			}
+		}
+	}
+	return
+}
+
+// Close stops every watcher created by the manager and closes the underlying
+// watcher.
 func (d *DelayableFileWatchMgr) Close() error {
+	d.mx.Lock()
+	if d.closed {
+		d.mx.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.done)
 
+	for w := range d.all {
+		w.stop()
+	}
+	d.all = make(map[*delayedWatcher]struct{})
+	d.watchers = make(map[string]map[*delayedWatcher]struct{})
+	d.raw = make(map[string]int)
+	d.missing = make(map[string]struct{})
+	d.mx.Unlock()
+
+	return d.p.Close()
+}
+
+// Create makes a new watcher that runs action when any of the paths added to
+// it change.
+func (d *DelayableFileWatchMgr) Create(action Action) FileWatcher {
+	w := &delayedWatcher{
+		m:      d,
+		action: action,
+		kicks:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.closed {
+		w.stop()
+		return w
+	}
+	d.all[w] = struct{}{}
+	go w.run()
+	return w
+}
+
+type delayedWatcher struct {
+	m      *DelayableFileWatchMgr
+	action Action
+	kicks  chan struct{}
+
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
-func (d *DelayableFileWatchMgr) Create(action Action) error {
+func (w *delayedWatcher) run() {
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-w.kicks:
+			quiet = w.m.c.QuietTime()
+		case <-quiet:
+			quiet = nil
+			w.action()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *delayedWatcher) kick() {
+	select {
+	case w.kicks <- struct{}{}:
+	default:
+		// a kick is already pending and will restart the quiet time
+	}
+}
+
+func (w *delayedWatcher) stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *delayedWatcher) Add(path string) error {
+	select {
+	case <-w.done:
+		return ErrWatcherClosed
+	default:
+	}
+	return w.m.add(w, path)
+}
 
+func (w *delayedWatcher) Close() error {
+	w.stop()
+	return w.m.remove(w)
 }
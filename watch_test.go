@@ -1,16 +1,35 @@
 package main
 
 import (
+	"errors"
 	"github.com/fsnotify/fsnotify"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 )
 
-func callCounter() (func(), *int) {
-	i := 0
+func callCounter() (func(), chan struct{}) {
+	calls := make(chan struct{}, 16)
 	return func() {
-		i++
-	}, &i
+		calls <- struct{}{}
+	}, calls
+}
+
+func expectCalls(t *testing.T, name string, calls chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-calls:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected %d calls, got %d", name, n, i)
+		}
+	}
+
+	select {
+	case <-calls:
+		t.Fatalf("%s: expected %d calls, got more", name, n)
+	case <-time.After(100 * time.Millisecond):
+	}
 }
 
 type mockFileWatcher struct {
@@ -19,13 +38,15 @@ type mockFileWatcher struct {
 	errors                            chan error
 	watched                           []string
 	closed                            bool
+	// paths that don't exist, if set
+	missing func(path string) bool
 }
 
 func (m *mockFileWatcher) Close() error {
-	if closed {
+	if m.closed {
 		panic("double closed!")
 	}
-	closed = true
+	m.closed = true
 	return m.closeError
 }
 
@@ -36,8 +57,24 @@ func (m *mockFileWatcher) Add(path string) error {
 		}
 	}
 
+	if m.addError != nil {
+		return m.addError
+	}
+	if m.missing != nil && m.missing(path) {
+		return &os.PathError{Op: "add", Path: path, Err: os.ErrNotExist}
+	}
 	m.watched = append(m.watched, path)
-	return m.addError
+	return nil
+}
+
+func (m *mockFileWatcher) Remove(path string) error {
+	for i, v := range m.watched {
+		if v == path {
+			m.watched = append(m.watched[:i], m.watched[i+1:]...)
+			return m.removeError
+		}
+	}
+	panic("removed unwatched path")
 }
 
 func (m *mockFileWatcher) Events() chan fsnotify.Event {
@@ -55,14 +92,264 @@ func mockFW() *mockFileWatcher {
 		make(chan error),
 		[]string{},
 		false,
+		nil,
 	}
 }
 
-func makeDelayer() (<-chan time.Time, chan<- time.Time)
+// the delayer fires whenever a value is sent down the returned send channel
+func makeDelayer() (TimeDelaySupplier, chan<- time.Time) {
+	c := make(chan time.Time)
+	return func() <-chan time.Time {
+		return c
+	}, c
+}
+
+func fire(t *testing.T, c chan<- time.Time) {
+	select {
+	case c <- time.Now():
+	case <-time.After(time.Second):
+		t.Fatalf("nothing was waiting on the quiet time")
+	}
+}
 
 func TestWatcherNoDoubleAdd(t *testing.T) {
-	a, aCount := callCounter()
+	a, _ := callCounter()
+	b, _ := callCounter()
+
+	watcher := mockFW()
+	delay, _ := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, watcher)
+	defer mgr.Close()
+
+	wa := mgr.Create(a)
+	wb := mgr.Create(b)
+	for _, path := range []string{"x", "./x", "y"} {
+		if err := wa.Add(path); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := wb.Add(path); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if len(watcher.watched) != 2 {
+		t.Errorf("incorrect number of raw watches - expected %d, got %v", 2, watcher.watched)
+	}
+}
+
+func TestWatcherDebounces(t *testing.T) {
+	a, aCalls := callCounter()
+	b, bCalls := callCounter()
+
+	watcher := mockFW()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: QuietPeriod(50 * time.Millisecond)}, watcher)
+	defer mgr.Close()
+
+	wa := mgr.Create(a)
+	wa.Add("x")
+	wa.Add("y")
+	wb := mgr.Create(b)
+	wb.Add("z")
+
+	watcher.events <- fsnotify.Event{Name: "x", Op: fsnotify.Write}
+	watcher.events <- fsnotify.Event{Name: "y", Op: fsnotify.Write}
+	watcher.events <- fsnotify.Event{Name: "./x", Op: fsnotify.Write}
+	watcher.events <- fsnotify.Event{Name: "unwatched", Op: fsnotify.Write}
+
+	expectCalls(t, "a", aCalls, 1)
+	expectCalls(t, "b", bCalls, 0)
+
+	watcher.events <- fsnotify.Event{Name: "z", Op: fsnotify.Create}
+
+	expectCalls(t, "b", bCalls, 1)
+	expectCalls(t, "a", aCalls, 0)
+}
+
+func TestWatcherIgnoresChmod(t *testing.T) {
+	a, aCalls := callCounter()
+
+	watcher := mockFW()
+	delay, _ := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, watcher)
+	defer mgr.Close()
+
+	mgr.Create(a).Add("x")
+	watcher.events <- fsnotify.Event{Name: "x", Op: fsnotify.Chmod}
+
+	expectCalls(t, "a", aCalls, 0)
+}
+
+func TestWatcherRewatchesReplacedFiles(t *testing.T) {
+	a, aCalls := callCounter()
+
+	watcher := mockFW()
+	delay, quiet := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, watcher)
+	defer mgr.Close()
+
+	mgr.Create(a).Add("x")
+	watcher.events <- fsnotify.Event{Name: "x", Op: fsnotify.Rename}
+	fire(t, quiet)
+
+	expectCalls(t, "a", aCalls, 1)
+	if len(watcher.watched) != 1 || watcher.watched[0] != "x" {
+		t.Errorf("replaced file was not watched again, watching %v", watcher.watched)
+	}
+}
+
+func TestWatcherDirectories(t *testing.T) {
+	a, aCalls := callCounter()
+
+	watcher := mockFW()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: QuietPeriod(50 * time.Millisecond)}, watcher)
+	defer mgr.Close()
+
+	mgr.Create(a).Add("data")
+	watcher.events <- fsnotify.Event{Name: "data/x.json", Op: fsnotify.Write}
+	expectCalls(t, "write", aCalls, 0)
+
+	for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Remove, fsnotify.Rename} {
+		watcher.events <- fsnotify.Event{Name: "data/x.json", Op: op}
+		expectCalls(t, op.String(), aCalls, 1)
+	}
+
+	watcher.events <- fsnotify.Event{Name: "data/sub/x.json", Op: fsnotify.Create}
+	expectCalls(t, "nested create", aCalls, 0)
+}
+
+func TestWatcherClose(t *testing.T) {
+	a, aCalls := callCounter()
+	b, _ := callCounter()
+
+	watcher := mockFW()
+	delay, _ := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, watcher)
+
+	wa := mgr.Create(a)
+	wa.Add("x")
+	wa.Add("y")
+	wb := mgr.Create(b)
+	wb.Add("y")
+
+	if err := wa.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(watcher.watched) != 1 || watcher.watched[0] != "y" {
+		t.Errorf("incorrect raw watches after close - expected %v, got %v", []string{"y"}, watcher.watched)
+	}
+	if err := wa.Add("z"); err != ErrWatcherClosed {
+		t.Errorf("incorrect error adding to closed watcher - expected %v, got %v", ErrWatcherClosed, err)
+	}
+
+	watcher.events <- fsnotify.Event{Name: "x", Op: fsnotify.Write}
+	expectCalls(t, "a", aCalls, 0)
+
+	if err := mgr.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !watcher.closed {
+		t.Errorf("raw watcher was not closed")
+	}
+	if err := wb.Add("z"); err != ErrWatcherClosed {
+		t.Errorf("incorrect error adding after manager close - expected %v, got %v", ErrWatcherClosed, err)
+	}
+	if err := mgr.Close(); err != nil {
+		t.Errorf("unexpected error closing twice: %s", err)
+	}
+}
+
+func TestWatcherErrors(t *testing.T) {
+	addError := errors.New("add failed")
+	errs := make(chan error, 1)
 
 	watcher := mockFW()
+	watcher.addError = addError
+	delay, _ := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{
+		QuietTime: delay,
+		Errors: func(err error) {
+			errs <- err
+		},
+	}, watcher)
+	defer mgr.Close()
 
+	a, _ := callCounter()
+	if err := mgr.Create(a).Add("x"); err != addError {
+		t.Errorf("incorrect error - expected %v, got %v", addError, err)
+	}
+
+	rawError := errors.New("raw failure")
+	watcher.errors <- rawError
+	select {
+	case err := <-errs:
+		if err != rawError {
+			t.Errorf("incorrect error - expected %v, got %v", rawError, err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("error was not passed on")
+	}
+}
+
+func TestWatcherStopsWhenRawCloses(t *testing.T) {
+	watcher := mockFW()
+	delay, _ := makeDelayer()
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{QuietTime: delay}, watcher)
+	defer mgr.Close()
+
+	close(watcher.events)
+	time.Sleep(50 * time.Millisecond)
+	// nothing is left receiving once the manager has stopped
+	select {
+	case watcher.errors <- errors.New("late"):
+		t.Errorf("manager kept running after its raw watcher closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatcherMissingFiles(t *testing.T) {
+	a, aCalls := callCounter()
+	errs := make(chan error, 1)
+
+	exists := map[string]bool{"dir": true}
+	watcher := mockFW()
+	watcher.missing = func(path string) bool {
+		return !exists[path]
+	}
+	mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{
+		QuietTime: QuietPeriod(50 * time.Millisecond),
+		Errors: func(err error) {
+			errs <- err
+		},
+	}, watcher)
+	defer mgr.Close()
+
+	expectWatched := func(when string, paths ...string) {
+		if !reflect.DeepEqual(watcher.watched, paths) {
+			t.Errorf("%s: incorrect raw watches - expected %v, got %v", when, paths, watcher.watched)
+		}
+	}
+
+	if err := mgr.Create(a).Add("dir/x"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectWatched("missing", "dir")
+
+	exists["dir/x"] = true
+	watcher.events <- fsnotify.Event{Name: "dir/x", Op: fsnotify.Create}
+	expectCalls(t, "created", aCalls, 1)
+	expectWatched("created", "dir/x")
+
+	delete(exists, "dir/x")
+	watcher.events <- fsnotify.Event{Name: "dir/x", Op: fsnotify.Remove}
+	expectCalls(t, "removed", aCalls, 1)
+	expectWatched("removed", "dir")
+
+	if err := mgr.Create(a).Add("gone/x"); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error watching in a missing directory, got %v", err)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error: %s", err)
+	default:
+	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/parallelblock/yate/cmd"
+	"github.com/spf13/cobra"
+)
+
+var watchQuietTime time.Duration
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Build every resource, then rebuild them as their files change",
+	Long: `Watch builds every resource declared in the config file like build does,
+then keeps running, rebuilding a resource whenever one of the templates it
+used or its config changes. Only the affected resources are rebuilt.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(c *cobra.Command, args []string) error {
+		p, err := loadProject()
+		if err != nil {
+			return err
+		}
+		overrides, err := projectOverrides()
+		if err != nil {
+			return err
+		}
+
+		raw, err := NewFsnotifyWatcher()
+		if err != nil {
+			return err
+		}
+		mgr := NewDelayableFileWatchMgr(DelayableFileWatchMgrCfg{
+			QuietTime: QuietPeriod(watchQuietTime),
+			Errors: func(err error) {
+				fmt.Fprintf(os.Stderr, "watch error: %s\n", err)
+			},
+		}, raw)
+		defer mgr.Close()
+
+		// components are shared between rebuilds and reparsed once edited
+		components := NewCacheComponentResolver(p.ReadFile)
+		components.Validate = ModTimeValidator(p.Stat)
+		components.Globber = p.Glob
+
+		inc := &IncrementalBuilder{
+			Load: func() (ResourceConfigSource, error) {
+				return p.Source()
+			},
+			Components: func() ComponentResolver {
+				return components
+			},
+			Open:      p.Open,
+			Watcher:   mgr,
+			Path:      p.Path,
+			Report:    reportBuild,
+			Overrides: overrides,
+		}
+		if err := inc.Start(filepath.Base(p.File)); err != nil {
+			return err
+		}
+
+		fmt.Println("watching for changes, press ctrl+c to stop")
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		return nil
+	},
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchQuietTime, "quiet", 100*time.Millisecond, "how long files must stop changing before rebuilding")
+	addVarFlag(watchCmd)
+	cmd.AddCommand(watchCmd)
+}